@@ -247,6 +247,7 @@ type CloudProviderID string
 const (
 	CloudProviderALI       CloudProviderID = "alicloud"
 	CloudProviderAWS       CloudProviderID = "aws"
+	CloudProviderAzure     CloudProviderID = "azure"
 	CloudProviderBareMetal CloudProviderID = "baremetal"
 	CloudProviderDO        CloudProviderID = "digitalocean"
 	CloudProviderGCE       CloudProviderID = "gce"
@@ -712,6 +712,9 @@ type CloudConfiguration struct {
 	SpotinstOrientation *string `json:"spotinstOrientation,omitempty"`
 	// Openstack cloud-config options
 	Openstack *OpenstackConfiguration `json:"openstack,omitempty"`
+	// Azure cloud-config options
+	AzureResourceGroup  *string `json:"azureResourceGroup,omitempty"`
+	AzureSubscriptionID *string `json:"azureSubscriptionID,omitempty"`
 }
 
 // HasAdmissionController checks if a specific admission controller is enabled
@@ -1516,6 +1516,8 @@ func autoConvert_v1alpha2_CloudConfiguration_To_kops_CloudConfiguration(in *Clou
 	} else {
 		out.Openstack = nil
 	}
+	out.AzureResourceGroup = in.AzureResourceGroup
+	out.AzureSubscriptionID = in.AzureSubscriptionID
 	return nil
 }
 
@@ -1548,6 +1550,8 @@ func autoConvert_kops_CloudConfiguration_To_v1alpha2_CloudConfiguration(in *kops
 	} else {
 		out.Openstack = nil
 	}
+	out.AzureResourceGroup = in.AzureResourceGroup
+	out.AzureSubscriptionID = in.AzureSubscriptionID
 	return nil
 }
 
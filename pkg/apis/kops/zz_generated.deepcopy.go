@@ -552,6 +552,16 @@ func (in *CloudConfiguration) DeepCopyInto(out *CloudConfiguration) {
 		*out = new(OpenstackConfiguration)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.AzureResourceGroup != nil {
+		in, out := &in.AzureResourceGroup, &out.AzureResourceGroup
+		*out = new(string)
+		**out = **in
+	}
+	if in.AzureSubscriptionID != nil {
+		in, out := &in.AzureSubscriptionID, &out.AzureSubscriptionID
+		*out = new(string)
+		**out = **in
+	}
 	return
 }
 
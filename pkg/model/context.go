@@ -227,6 +227,18 @@ func (m *KopsModelContext) CloudTagsForInstanceGroup(ig *kops.InstanceGroup) (ma
 	return labels, nil
 }
 
+// CloudTagsForCluster computes the tags to apply to cluster-wide resources,
+// merging any user-specified cloud labels from the cluster spec
+func (m *KopsModelContext) CloudTagsForCluster() (map[string]string, error) {
+	tags := m.CloudTags(m.ClusterName(), false)
+
+	for k, v := range m.Cluster.Spec.CloudLabels {
+		tags[k] = v
+	}
+
+	return tags, nil
+}
+
 // CloudTags computes the tags to apply to a normal cloud resource with the specified name
 func (m *KopsModelContext) CloudTags(name string, shared bool) map[string]string {
 	tags := make(map[string]string)
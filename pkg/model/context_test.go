@@ -23,6 +23,33 @@ import (
 	"k8s.io/kops/pkg/apis/kops"
 )
 
+func Test_CloudTagsForCluster(t *testing.T) {
+	c := &KopsModelContext{
+		Cluster: &kops.Cluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "mycluster.example.com",
+			},
+			Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderAWS),
+				CloudLabels: map[string]string{
+					"Owner": "platform-team",
+				},
+			},
+		},
+	}
+
+	tags, err := c.CloudTagsForCluster()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tags["Owner"] != "platform-team" {
+		t.Errorf("expected user-specified label in cluster tags, got %+v", tags)
+	}
+	if tags["KubernetesCluster"] != "mycluster.example.com" {
+		t.Errorf("expected KubernetesCluster tag, got %+v", tags)
+	}
+}
+
 func Test_GetELBName32(t *testing.T) {
 	grid := []struct {
 		Prefix      string
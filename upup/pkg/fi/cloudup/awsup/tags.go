@@ -0,0 +1,69 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package awsup
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxAWSTagValueLength is the maximum length AWS allows for a tag value
+	MaxAWSTagValueLength = 256
+
+	// awsReservedTagPrefix is the prefix AWS reserves for its own tags; user tags may not use it
+	awsReservedTagPrefix = "aws:"
+)
+
+// EncodeAWSTag sanitizes a string for use as an AWS tag value,
+// replacing characters the tagging APIs reject and truncating to the maximum length
+func EncodeAWSTag(s string) string {
+	var b bytes.Buffer
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+			b.WriteByte(c)
+		case c == ' ', c == '+', c == '-', c == '=', c == '.', c == '_', c == ':', c == '/', c == '@':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	encoded := b.String()
+	if len(encoded) > MaxAWSTagValueLength {
+		encoded = encoded[:MaxAWSTagValueLength]
+	}
+	return encoded
+}
+
+// EncodeAWSLabel sanitizes a string for use as an AWS tag key or value;
+// it applies the same rules as EncodeAWSTag
+func EncodeAWSLabel(s string) string {
+	return EncodeAWSTag(s)
+}
+
+// ValidateAWSTagKey checks that a tag key does not use a reserved prefix
+func ValidateAWSTagKey(key string) error {
+	if strings.HasPrefix(strings.ToLower(key), awsReservedTagPrefix) {
+		return fmt.Errorf("tag key %q uses reserved prefix %q", key, awsReservedTagPrefix)
+	}
+	return nil
+}
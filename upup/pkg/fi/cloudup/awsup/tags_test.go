@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package awsup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeAWSTag(t *testing.T) {
+	grid := []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			"cluster.example.com",
+			"cluster.example.com",
+		},
+		{
+			"owner=platform team",
+			"owner=platform team",
+		},
+		{
+			"bad\tchars\nhere",
+			"bad_chars_here",
+		},
+		{
+			strings.Repeat("a", 300),
+			strings.Repeat("a", 256),
+		},
+	}
+	for _, g := range grid {
+		actual := EncodeAWSTag(g.Input)
+		if actual != g.Expected {
+			t.Errorf("unexpected result from %q.  expected %q, got %q", g.Input, g.Expected, actual)
+		}
+	}
+}
+
+func TestValidateAWSTagKey(t *testing.T) {
+	for _, key := range []string{"KubernetesCluster", "kubernetes.io/cluster/example", "Owner"} {
+		if err := ValidateAWSTagKey(key); err != nil {
+			t.Errorf("unexpected error validating tag key %q: %v", key, err)
+		}
+	}
+
+	for _, key := range []string{"aws:cloudformation:stack-name", "AWS:Anything"} {
+		if err := ValidateAWSTagKey(key); err == nil {
+			t.Errorf("expected error validating tag key %q", key)
+		}
+	}
+}
@@ -28,28 +28,54 @@ When defining a new function:
 package cloudup
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
 
 	"github.com/Masterminds/sprig"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog"
 	kopscontrollerconfig "k8s.io/kops/cmd/kops-controller/pkg/config"
 	"k8s.io/kops/pkg/apis/kops"
 	"k8s.io/kops/pkg/apis/kops/util"
+	"k8s.io/kops/pkg/assets"
 	"k8s.io/kops/pkg/dns"
 	"k8s.io/kops/pkg/featureflag"
+	"k8s.io/kops/pkg/flagbuilder"
 	"k8s.io/kops/pkg/model"
+	"k8s.io/kops/pkg/model/components"
 	"k8s.io/kops/pkg/resources/spotinst"
 	"k8s.io/kops/pkg/wellknownports"
 	"k8s.io/kops/upup/pkg/fi"
+	"k8s.io/kops/upup/pkg/fi/cloudup/awsup"
 	"k8s.io/kops/upup/pkg/fi/cloudup/gce"
 	"k8s.io/kops/util/pkg/env"
+	"k8s.io/kops/util/pkg/hashing"
+	"k8s.io/kops/util/pkg/reflectutils"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// architectureAnnotation can be set on an InstanceGroup (or on the Cluster,
+	// as a cluster-wide default) to override the target architecture
+	architectureAnnotation = "kops.k8s.io/architecture"
+	// defaultArchitecture is assumed when no annotation is present
+	defaultArchitecture = "amd64"
 )
 
 // TemplateFunctions provides a collection of methods used throughout the templates
@@ -59,30 +85,215 @@ type TemplateFunctions struct {
 	modelContext   *model.KopsModelContext
 	region         string
 	tags           sets.String
+
+	// templateEnv holds the KOPS_TEMPLATE_* environment variables, captured
+	// once so that repeated renders see consistent values
+	templateEnv map[string]string
+}
+
+// templateEnvPrefix is the prefix an environment variable must have to be
+// visible to templates via the Env function
+const templateEnvPrefix = "KOPS_TEMPLATE_"
+
+// Env returns the value of an environment variable for use in templates.
+// Only variables with the KOPS_TEMPLATE_ prefix may be referenced; anything
+// else fails the render.
+func (tf *TemplateFunctions) Env(name string) (string, error) {
+	if !strings.HasPrefix(name, templateEnvPrefix) {
+		return "", fmt.Errorf("environment variable %q is not permitted in templates; only %s* variables can be referenced", name, templateEnvPrefix)
+	}
+	v, found := tf.templateEnv[name]
+	if !found {
+		return "", fmt.Errorf("environment variable %q is not set", name)
+	}
+	return v, nil
 }
 
 // This will define the available functions we can use in our YAML models
 // If we are trying to get a new function implemented it MUST
 // be defined here.
 func (tf *TemplateFunctions) AddTo(dest template.FuncMap, secretStore fi.SecretStore) (err error) {
+	if tf.templateEnv == nil {
+		tf.templateEnv = make(map[string]string)
+		for _, e := range os.Environ() {
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) == 2 && strings.HasPrefix(kv[0], templateEnvPrefix) {
+				tf.templateEnv[kv[0]] = kv[1]
+			}
+		}
+	}
+	dest["Env"] = tf.Env
+
 	dest["EtcdScheme"] = tf.EtcdScheme
 	dest["SharedVPC"] = tf.SharedVPC
 	dest["ToJSON"] = tf.ToJSON
+	dest["FromJSON"] = tf.FromJSON
+	dest["ToYAML"] = tf.ToYAML
+	dest["FromYAML"] = tf.FromYAML
 	dest["UseBootstrapTokens"] = tf.modelContext.UseBootstrapTokens
-	dest["UseEtcdTLS"] = tf.modelContext.UseEtcdTLS
-	// Remember that we may be on a different arch from the target.  Hard-code for now.
-	dest["Arch"] = func() string { return "amd64" }
+	// Remember that we may be on a different arch from the target
+	dest["Arch"] = tf.Arch
 	dest["replace"] = func(s, find, replace string) string {
 		return strings.Replace(s, find, replace, -1)
 	}
 	dest["join"] = func(a []string, sep string) string {
 		return strings.Join(a, sep)
 	}
+	// Unlike strings.Split, an empty input produces an empty slice,
+	// so templates can range over the result to emit flags
+	dest["split"] = func(s, sep string) []string {
+		if s == "" {
+			return []string{}
+		}
+		return strings.Split(s, sep)
+	}
+	dest["splitN"] = func(s, sep string, n int) []string {
+		if s == "" {
+			return []string{}
+		}
+		return strings.SplitN(s, sep, n)
+	}
+	dest["SplitOn"] = strings.Split
+	dest["SplitComma"] = func(s string) []string {
+		return strings.Split(s, ",")
+	}
+	dest["SplitCommaTrimmed"] = func(s string) []string {
+		elements := strings.Split(s, ",")
+		for i := range elements {
+			elements[i] = strings.TrimSpace(elements[i])
+		}
+		return elements
+	}
+	dest["Base64Encode"] = func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	dest["Base64Decode"] = tf.Base64Decode
+	dest["Base64Gzip"] = tf.Base64Gzip
 
 	sprigTxtFuncMap := sprig.TxtFuncMap()
-	dest["indent"] = sprigTxtFuncMap["indent"]
+	// sprig's indent also indents the empty line after a trailing newline,
+	// which leaves dangling whitespace; trim it so that indenting content
+	// ending in "\n" still produces clean YAML
+	indent := func(spaces int, v string) string {
+		indented := sprigTxtFuncMap["indent"].(func(int, string) string)(spaces, v)
+		return strings.TrimRight(indented, " ")
+	}
+	nindent := func(spaces int, v string) string {
+		return "\n" + indent(spaces, v)
+	}
+	dest["indent"] = indent
+	dest["nindent"] = nindent
+	// Indent prepends the given number of spaces to every line, including
+	// the first, following the same convention as Helm's indent
+	dest["Indent"] = indent
+	// Nindent prepends a newline before the indented content, matching the
+	// Helm nindent convention
+	dest["Nindent"] = nindent
+	dest["lower"] = sprigTxtFuncMap["lower"]
+	dest["upper"] = sprigTxtFuncMap["upper"]
+	dest["title"] = sprigTxtFuncMap["title"]
+	dest["sha256"] = sprigTxtFuncMap["sha256sum"]
+	dest["sha1"] = sprigTxtFuncMap["sha1sum"]
+	dest["quote"] = sprigTxtFuncMap["quote"]
+	dest["squote"] = sprigTxtFuncMap["squote"]
+	dest["ternary"] = sprigTxtFuncMap["ternary"]
+	dest["contains"] = sprigTxtFuncMap["contains"]
+	dest["hasPrefix"] = sprigTxtFuncMap["hasPrefix"]
+	dest["hasSuffix"] = sprigTxtFuncMap["hasSuffix"]
+	dest["trim"] = sprigTxtFuncMap["trim"]
+	dest["trimPrefix"] = sprigTxtFuncMap["trimPrefix"]
+	dest["trimSuffix"] = sprigTxtFuncMap["trimSuffix"]
+	// Unlike replace, these strip at most one occurrence, and only at the
+	// ends of the string
+	dest["TrimPrefix"] = strings.TrimPrefix
+	dest["TrimSuffix"] = strings.TrimSuffix
+	dest["Upper"] = strings.ToUpper
+	dest["Lower"] = strings.ToLower
+	// Quote and Squote wrap strings for embedding in JSON or shell; values
+	// containing newlines or null bytes fail the render rather than
+	// silently producing malformed output
+	dest["Quote"] = func(s string) (string, error) {
+		if strings.ContainsAny(s, "\n\x00") {
+			return "", fmt.Errorf("cannot quote value containing newlines or null bytes: %q", s)
+		}
+		return strconv.Quote(s), nil
+	}
+	dest["Squote"] = func(s string) (string, error) {
+		if strings.ContainsAny(s, "\n\x00") {
+			return "", fmt.Errorf("cannot quote value containing newlines or null bytes: %q", s)
+		}
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'", nil
+	}
+
+	// The regex helpers compile each pattern once per render; the same
+	// pattern tends to be used many times across a model
+	regexCache := map[string]*regexp.Regexp{}
+	compileRegex := func(pattern string) (*regexp.Regexp, error) {
+		if re, ok := regexCache[pattern]; ok {
+			return re, nil
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling regex %q: %v", pattern, err)
+		}
+		regexCache[pattern] = re
+		return re, nil
+	}
+	dest["regexMatch"] = func(pattern, s string) (bool, error) {
+		re, err := compileRegex(pattern)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	}
+	dest["regexReplaceAll"] = func(pattern, s, repl string) (string, error) {
+		re, err := compileRegex(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, repl), nil
+	}
 
 	dest["ClusterName"] = tf.modelContext.ClusterName
+	dest["IsGossipCluster"] = tf.IsGossipCluster
+	dest["MasterInternalName"] = tf.MasterInternalName
+	dest["MasterPublicName"] = tf.MasterPublicName
+	dest["APIServerURL"] = tf.APIServerURL
+	dest["KubernetesAPIAccess"] = tf.KubernetesAPIAccess
+	dest["SSHAccess"] = tf.SSHAccess
+	dest["IsHighlyAvailable"] = tf.IsHighlyAvailable
+	dest["MasterCount"] = tf.MasterCount
+	dest["NodeCount"] = tf.NodeCount
+	dest["HasFeatureGate"] = tf.HasFeatureGate
+	// FeatureGateEnabled is an alias for HasFeatureGate, matching the
+	// kubernetes flag terminology
+	dest["FeatureGateEnabled"] = tf.HasFeatureGate
+	dest["IsPrivateTopology"] = tf.IsPrivateTopology
+	dest["UsesBastion"] = tf.UsesBastion
+	dest["IsPublicAPI"] = tf.IsPublicAPI
+	dest["NetworkCIDR"] = tf.NetworkCIDR
+	dest["NonMasqueradeCIDR"] = tf.NonMasqueradeCIDR
+	dest["PodCIDR"] = tf.PodCIDR
+	dest["ServiceClusterIPRange"] = func() string {
+		return tf.cluster.Spec.ServiceClusterIPRange
+	}
+	dest["ClusterDNSIP"] = tf.ClusterDNSIP
+	dest["EtcdClusters"] = tf.EtcdClusters
+	dest["GetEtcdCluster"] = tf.GetEtcdCluster
+	// Sorted so rendered output is deterministic and idempotency checks
+	// comparing byte-for-byte stay stable
+	dest["EtcdClusterNames"] = func() []string {
+		var names []string
+		for _, etcd := range tf.cluster.Spec.EtcdClusters {
+			names = append(names, etcd.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	dest["EtcdMemberCount"] = tf.EtcdMemberCount
+	dest["EtcdQuorumSize"] = tf.EtcdQuorumSize
+	dest["UseEtcdTLS"] = tf.UseEtcdTLS
+	dest["EtcdVersion"] = tf.EtcdVersion
 	dest["HasTag"] = tf.HasTag
 	dest["WithDefaultBool"] = func(v *bool, defaultValue bool) bool {
 		if v != nil {
@@ -90,29 +301,305 @@ func (tf *TemplateFunctions) AddTo(dest template.FuncMap, secretStore fi.SecretS
 		}
 		return defaultValue
 	}
+	dest["WithDefaultString"] = func(v *string, defaultValue string) string {
+		if v != nil {
+			return *v
+		}
+		return defaultValue
+	}
+	dest["WithDefaultInt"] = func(v *int32, defaultValue int) int {
+		if v != nil {
+			return int(*v)
+		}
+		return defaultValue
+	}
+	dest["WithDefaultInt32"] = func(v *int32, defaultValue int32) int32 {
+		if v != nil {
+			return *v
+		}
+		return defaultValue
+	}
+	dest["WithDefaultInt64"] = func(v *int64, defaultValue int64) int64 {
+		if v != nil {
+			return *v
+		}
+		return defaultValue
+	}
+
+	dest["list"] = sprigTxtFuncMap["list"]
+	dest["dict"] = func(pairs ...interface{}) (map[string]interface{}, error) {
+		if len(pairs)%2 != 0 {
+			return nil, fmt.Errorf("dict requires an even number of arguments")
+		}
+		d := make(map[string]interface{})
+		for i := 0; i < len(pairs); i += 2 {
+			key, ok := pairs[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %T", pairs[i])
+			}
+			d[key] = pairs[i+1]
+		}
+		return d, nil
+	}
+
+	// seq and until produce integer slices for templates to range over;
+	// empty or inverted ranges yield an empty slice rather than panicking
+	dest["seq"] = func(start, end int) []int {
+		s := []int{}
+		for i := start; i <= end; i++ {
+			s = append(s, i)
+		}
+		return s
+	}
+	dest["until"] = func(n int) []int {
+		s := []int{}
+		for i := 0; i < n; i++ {
+			s = append(s, i)
+		}
+		return s
+	}
+
+	// merge combines label/tag maps without mutating its inputs; later
+	// arguments take precedence over earlier ones
+	dest["merge"] = func(maps ...map[string]string) map[string]string {
+		merged := make(map[string]string)
+		for _, m := range maps {
+			for k, v := range m {
+				merged[k] = v
+			}
+		}
+		return merged
+	}
+
+	// required aborts the render with the caller's message when the value is
+	// missing; text/template wraps the error with the template name
+	dest["required"] = func(msg string, v interface{}) (interface{}, error) {
+		if v == nil {
+			return nil, errors.New(msg)
+		}
+		switch val := reflect.ValueOf(v); val.Kind() {
+		case reflect.String:
+			if val.Len() == 0 {
+				return nil, errors.New(msg)
+			}
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			if val.IsNil() {
+				return nil, errors.New(msg)
+			}
+		}
+		return v, nil
+	}
+
+	// The CIDR helpers let templates derive per-zone subnets and well-known
+	// addresses from the cluster CIDRs; malformed CIDRs and out-of-range
+	// indexes fail the render
+	dest["cidrSubnet"] = func(base string, newBits, num int) (string, error) {
+		_, ipnet, err := net.ParseCIDR(base)
+		if err != nil {
+			return "", fmt.Errorf("cannot parse CIDR %q: %v", base, err)
+		}
+		ip4 := ipnet.IP.To4()
+		if ip4 == nil {
+			return "", fmt.Errorf("unexpected IP address type: %s", ipnet)
+		}
+		prefix, _ := ipnet.Mask.Size()
+		newPrefix := prefix + newBits
+		if newBits <= 0 || newPrefix > 32 {
+			return "", fmt.Errorf("cannot extend /%d prefix by %d bits", prefix, newBits)
+		}
+		if num < 0 || num >= 1<<uint(newBits) {
+			return "", fmt.Errorf("subnet index %d is out of range for %d additional bits", num, newBits)
+		}
+		n := binary.BigEndian.Uint32(ip4)
+		n += uint32(num) << uint(32-newPrefix)
+		subnetIP := make(net.IP, len(ip4))
+		binary.BigEndian.PutUint32(subnetIP, n)
+		return (&net.IPNet{IP: subnetIP, Mask: net.CIDRMask(newPrefix, 32)}).String(), nil
+	}
+	dest["cidrHost"] = func(cidr string, n int) (string, error) {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return "", fmt.Errorf("cannot parse CIDR %q: %v", cidr, err)
+		}
+		ip4 := ipnet.IP.To4()
+		if ip4 == nil {
+			return "", fmt.Errorf("unexpected IP address type: %s", ipnet)
+		}
+		prefix, _ := ipnet.Mask.Size()
+		if n < 0 || n >= 1<<uint(32-prefix) {
+			return "", fmt.Errorf("host index %d is out of range for %s", n, cidr)
+		}
+		hostIP := make(net.IP, len(ip4))
+		binary.BigEndian.PutUint32(hostIP, binary.BigEndian.Uint32(ip4)+uint32(n))
+		return hostIP.String(), nil
+	}
+	// The address-family helpers let dual-stack aware templates pick flags
+	// per family; IsIPv4/IsIPv6 return false for anything unparseable
+	dest["IsIPv4"] = func(s string) bool {
+		parsed := net.ParseIP(s)
+		return parsed != nil && parsed.To4() != nil
+	}
+	dest["IsIPv6"] = func(s string) bool {
+		parsed := net.ParseIP(s)
+		return parsed != nil && parsed.To4() == nil
+	}
+	dest["ParseCIDRIP"] = func(cidr string) (string, error) {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return "", fmt.Errorf("cannot parse CIDR %q: %v", cidr, err)
+		}
+		return ip.String(), nil
+	}
+	dest["cidrContains"] = func(cidr, ip string) (bool, error) {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return false, fmt.Errorf("cannot parse CIDR %q: %v", cidr, err)
+		}
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return false, fmt.Errorf("cannot parse IP address %q", ip)
+		}
+		return ipnet.Contains(parsed), nil
+	}
+
+	// The quantity helpers let templates do arithmetic on resource requests
+	// and still emit strings that parse as Kubernetes quantities
+	dest["MiB"] = func(n int) string {
+		return resource.NewQuantity(int64(n)<<20, resource.BinarySI).String()
+	}
+	dest["GiB"] = func(n int) string {
+		return resource.NewQuantity(int64(n)<<30, resource.BinarySI).String()
+	}
+	dest["parseQuantity"] = func(s string) (int64, error) {
+		q, err := resource.ParseQuantity(s)
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse quantity %q: %v", s, err)
+		}
+		return q.Value(), nil
+	}
+
+	// urlParse decomposes a URL so templates can pick out just the host or path
+	dest["urlParse"] = func(s string) (map[string]string, error) {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing url %q: %v", s, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("cannot parse url %q: scheme and host are required", s)
+		}
+		return map[string]string{
+			"scheme": u.Scheme,
+			"host":   u.Hostname(),
+			"port":   u.Port(),
+			"path":   u.Path,
+		}, nil
+	}
 
 	dest["GetInstanceGroup"] = tf.GetInstanceGroup
+	dest["GetMasterInstanceGroups"] = tf.GetMasterInstanceGroups
+	dest["GetNodeInstanceGroups"] = tf.GetNodeInstanceGroups
+	dest["GetBastionInstanceGroups"] = tf.GetBastionInstanceGroups
+	dest["GetSubnetsForRole"] = tf.GetSubnetsForRole
+	dest["SubnetsOfType"] = tf.SubnetsOfType
+	dest["GetSubnet"] = tf.GetSubnet
+	dest["InstanceGroupSubnets"] = tf.InstanceGroupSubnets
+	dest["InstanceGroupMachineType"] = tf.InstanceGroupMachineType
+	dest["InstanceGroupImage"] = tf.InstanceGroupImage
+	dest["InstanceGroupMinSize"] = tf.InstanceGroupMinSize
+	dest["InstanceGroupMaxSize"] = tf.InstanceGroupMaxSize
+	dest["CNIAssetURL"] = tf.CNIAssetURL
+	dest["CNIAssetHash"] = tf.CNIAssetHash
+	dest["Subnets"] = func() []kops.ClusterSubnetSpec {
+		return tf.cluster.Spec.Subnets
+	}
 	dest["CloudTags"] = tf.modelContext.CloudTagsForInstanceGroup
+	dest["ClusterCloudTags"] = tf.modelContext.CloudTagsForCluster
 	dest["KubeDNS"] = func() *kops.KubeDNSConfig {
 		return tf.cluster.Spec.KubeDNS
 	}
+	dest["KubeAPIServer"] = tf.KubeAPIServer
+	dest["KubeAPIServerFlag"] = tf.KubeAPIServerFlag
+	dest["HasAdmissionPlugin"] = tf.HasAdmissionPlugin
+	dest["HasDisabledAdmissionPlugin"] = tf.HasDisabledAdmissionPlugin
+	dest["KubeControllerManager"] = tf.KubeControllerManager
+	dest["KubeScheduler"] = tf.KubeScheduler
+	dest["CloudConfig"] = tf.CloudConfig
+	dest["KubeletConfig"] = tf.KubeletConfig
+	dest["MasterKubeletConfig"] = tf.MasterKubeletConfig
+	dest["Docker"] = tf.Docker
+	dest["ContainerRuntime"] = tf.ContainerRuntime
+	dest["IsContainerRuntime"] = tf.IsContainerRuntime
+	dest["Networking"] = func() *kops.NetworkingSpec {
+		return tf.cluster.Spec.Networking
+	}
+	dest["NetworkingProvider"] = tf.NetworkingProvider
+	dest["UsesCNI"] = tf.UsesCNI
+	dest["AuthorizationMode"] = tf.AuthorizationMode
+	dest["UseRBAC"] = tf.UseRBAC
+	dest["Authentication"] = tf.Authentication
+	dest["UseKopeioAuthentication"] = tf.UseKopeioAuthentication
+	dest["KubeProxy"] = tf.KubeProxy
+	dest["KubeProxyEnabled"] = tf.KubeProxyEnabled
+	dest["KubeletConfigForInstanceGroup"] = tf.KubeletConfigForInstanceGroup
 
 	dest["KopsControllerArgv"] = tf.KopsControllerArgv
 	dest["KopsControllerConfig"] = tf.KopsControllerConfig
 	dest["DnsControllerArgv"] = tf.DnsControllerArgv
+	dest["GossipSeeds"] = tf.GossipSeeds
+	dest["IsInternalDNS"] = tf.IsInternalDNS
 	dest["ExternalDnsArgv"] = tf.ExternalDnsArgv
 	dest["CloudControllerConfigArgv"] = tf.CloudControllerConfigArgv
 	// TODO: Only for GCE?
 	dest["EncodeGCELabel"] = gce.EncodeGCELabel
-	dest["Region"] = func() string {
-		return tf.region
+	dest["EncodeAWSTag"] = awsup.EncodeAWSTag
+	dest["EncodeAWSLabel"] = awsup.EncodeAWSLabel
+	dest["ValidateAWSTagKey"] = awsup.ValidateAWSTagKey
+	dest["Region"] = func() (string, error) {
+		if tf.region == "" {
+			return "", fmt.Errorf("region is not set")
+		}
+		return tf.region, nil
+	}
+	dest["CloudProvider"] = tf.CloudProvider
+	dest["IsCloudProvider"] = func(p string) bool {
+		return tf.cluster.Spec.CloudProvider == p
+	}
+	dest["Project"] = tf.Project
+	dest["IsAWS"] = tf.IsAWS
+	dest["IsGCE"] = tf.IsGCE
+	dest["IsVSphere"] = tf.IsVSphere
+	// DNSZone is either the name of the hosted zone (when it contains a dot)
+	// or the zone ID, matching how DnsControllerArgv interprets the value
+	dest["DNSZone"] = func() string {
+		return tf.cluster.Spec.DNSZone
+	}
+	// KubernetesVersion is normalized with any leading "v" stripped, as
+	// channels are inconsistent about including it
+	dest["KubernetesVersion"] = func() (string, error) {
+		if tf.cluster.Spec.KubernetesVersion == "" {
+			return "", fmt.Errorf("spec.kubernetesVersion is not set")
+		}
+		return strings.TrimPrefix(tf.cluster.Spec.KubernetesVersion, "v"), nil
+	}
+	dest["KubernetesMajorMinor"] = func() (string, error) {
+		sv, err := util.ParseKubernetesVersion(tf.cluster.Spec.KubernetesVersion)
+		if err != nil {
+			return "", fmt.Errorf("error parsing cluster kubernetes version %q: %v", tf.cluster.Spec.KubernetesVersion, err)
+		}
+		return fmt.Sprintf("%d.%d", sv.Major, sv.Minor), nil
 	}
+	dest["KubernetesVersionAtLeast"] = tf.KubernetesVersionAtLeast
+	// cluster.IsKubernetesGTE panics on an unparseable version, which
+	// text/template cannot recover from; fail the render instead
+	dest["IsKubernetesGTE"] = tf.KubernetesVersionAtLeast
+	dest["semverCompare"] = sprigTxtFuncMap["semverCompare"]
 
 	if featureflag.EnableExternalCloudController.Enabled() {
 		// will return openstack external ccm image location for current kubernetes version
 		dest["OpenStackCCM"] = tf.OpenStackCCM
 	}
 	dest["ProxyEnv"] = tf.ProxyEnv
+	dest["NoProxy"] = tf.NoProxy
 
 	dest["KopsSystemEnv"] = tf.KopsSystemEnv
 
@@ -153,23 +640,661 @@ func (tf *TemplateFunctions) AddTo(dest template.FuncMap, secretStore fi.SecretS
 	return nil
 }
 
-// ToJSON returns a json representation of the struct or on error an empty string
-func (tf *TemplateFunctions) ToJSON(data interface{}) string {
+// ToJSON returns a json representation of the struct; an error aborts the template render
+func (tf *TemplateFunctions) ToJSON(data interface{}) (string, error) {
 	encoded, err := json.Marshal(data)
 	if err != nil {
-		return ""
+		return "", fmt.Errorf("error marshaling to json: %v", err)
+	}
+
+	return string(encoded), nil
+}
+
+// FromJSON parses a json document into a value usable with `index` in templates
+func (tf *TemplateFunctions) FromJSON(s string) (interface{}, error) {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(s), &parsed); err != nil {
+		return nil, fmt.Errorf("error parsing json: %v", err)
+	}
+
+	return parsed, nil
+}
+
+// Arch returns the target architecture for the named instance group (e.g.
+// `{{ Arch "nodes" }}`), falling back to a cluster-wide annotation and
+// finally to amd64.  With no arguments it returns the cluster default.
+func (tf *TemplateFunctions) Arch(igName ...string) (string, error) {
+	if len(igName) > 1 {
+		return "", fmt.Errorf("Arch accepts at most one instance group name")
+	}
+	if len(igName) == 1 {
+		ig, err := tf.GetInstanceGroup(igName[0])
+		if err != nil {
+			return "", err
+		}
+		if arch := ig.ObjectMeta.Annotations[architectureAnnotation]; arch != "" {
+			return arch, nil
+		}
+	}
+	if arch := tf.cluster.ObjectMeta.Annotations[architectureAnnotation]; arch != "" {
+		return arch, nil
+	}
+	return defaultArchitecture, nil
+}
+
+// Base64Decode decodes a base64-encoded string, accepting both padded and
+// unpadded standard encodings; invalid input fails the template render
+func (tf *TemplateFunctions) Base64Decode(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		// Retry without padding, some sources strip the trailing '='
+		decoded, err = base64.RawStdEncoding.DecodeString(s)
+		if err != nil {
+			return "", fmt.Errorf("error decoding base64 value %q: %v", s, err)
+		}
+	}
+	return string(decoded), nil
+}
+
+// ToYAML returns a yaml representation of the struct; keys are emitted in a
+// deterministic order so repeated renders do not produce spurious diffs
+func (tf *TemplateFunctions) ToYAML(data interface{}) (string, error) {
+	encoded, err := yaml.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling to yaml: %v", err)
+	}
+
+	return string(encoded), nil
+}
+
+// FromYAML parses a yaml document, for re-reading user-supplied snippets inside a template
+func (tf *TemplateFunctions) FromYAML(s string) (interface{}, error) {
+	var parsed interface{}
+	if err := yaml.Unmarshal([]byte(s), &parsed); err != nil {
+		return nil, fmt.Errorf("error parsing yaml: %v", err)
+	}
+
+	return parsed, nil
+}
+
+// KubeAPIServer returns the cluster's KubeAPIServer config; a nil spec field
+// yields an empty struct so templates do not have to nil-check every access
+func (tf *TemplateFunctions) KubeAPIServer() *kops.KubeAPIServerConfig {
+	if tf.cluster.Spec.KubeAPIServer == nil {
+		return &kops.KubeAPIServerConfig{}
+	}
+	return tf.cluster.Spec.KubeAPIServer
+}
+
+// KubeAPIServerFlag returns the rendered value of a single kube-apiserver
+// flag, resolved through the flagbuilder tags on KubeAPIServerConfig
+func (tf *TemplateFunctions) KubeAPIServerFlag(name string) (string, error) {
+	flags, err := flagbuilder.BuildFlagsList(tf.KubeAPIServer())
+	if err != nil {
+		return "", fmt.Errorf("error building kube-apiserver flags: %v", err)
+	}
+	prefix := "--" + name + "="
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, prefix) {
+			return strings.TrimPrefix(flag, prefix), nil
+		}
+	}
+	return "", fmt.Errorf("flag %q is not set on kube-apiserver", name)
+}
+
+// HasAdmissionPlugin returns true if the named admission plugin is
+// in the kube-apiserver enabled plugins list
+func (tf *TemplateFunctions) HasAdmissionPlugin(name string) bool {
+	for _, plugin := range tf.KubeAPIServer().EnableAdmissionPlugins {
+		if plugin == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasDisabledAdmissionPlugin returns true if the named admission plugin is
+// in the kube-apiserver disabled plugins list
+func (tf *TemplateFunctions) HasDisabledAdmissionPlugin(name string) bool {
+	for _, plugin := range tf.KubeAPIServer().DisableAdmissionPlugins {
+		if plugin == name {
+			return true
+		}
+	}
+	return false
+}
+
+// KubeControllerManager returns the cluster's KubeControllerManager config,
+// following the same nil-safety as KubeAPIServer.
+//
+// We deliberately do not run the pkg/model/components defaulting here:
+// PopulateClusterSpec already runs KubeControllerManagerOptionsBuilder before
+// any templates are rendered, so on the rendering path this field is non-nil
+// and fully defaulted. Running the builder again would need an OptionsContext
+// (asset builder, parsed kubernetes version) that we don't have here; the
+// empty struct is only a guard against unpopulated specs, e.g. in tests.
+func (tf *TemplateFunctions) KubeControllerManager() *kops.KubeControllerManagerConfig {
+	if tf.cluster.Spec.KubeControllerManager == nil {
+		return &kops.KubeControllerManagerConfig{}
+	}
+	return tf.cluster.Spec.KubeControllerManager
+}
+
+// KubeScheduler returns the cluster's KubeScheduler config,
+// following the same nil-safety as KubeAPIServer.
+//
+// As with KubeControllerManager, the components defaulting is applied by
+// PopulateClusterSpec (via KubeSchedulerOptionsBuilder) before rendering, so
+// the empty struct is only returned for unpopulated specs.
+func (tf *TemplateFunctions) KubeScheduler() *kops.KubeSchedulerConfig {
+	if tf.cluster.Spec.KubeScheduler == nil {
+		return &kops.KubeSchedulerConfig{}
+	}
+	return tf.cluster.Spec.KubeScheduler
+}
+
+// IsPrivateTopology returns true if the cluster masters run in a private
+// topology; a nil topology defaults to public
+func (tf *TemplateFunctions) IsPrivateTopology() bool {
+	return tf.cluster.Spec.Topology != nil && tf.cluster.Spec.Topology.Masters == kops.TopologyPrivate
+}
+
+// UsesBastion returns true if the cluster topology includes a bastion
+func (tf *TemplateFunctions) UsesBastion() bool {
+	return tf.cluster.Spec.Topology != nil && tf.cluster.Spec.Topology.Bastion != nil
+}
+
+// IsPublicAPI returns true unless the kube-apiserver load balancer is
+// internal-only; a nil API block defaults to public
+func (tf *TemplateFunctions) IsPublicAPI() bool {
+	api := tf.cluster.Spec.API
+	if api == nil || api.LoadBalancer == nil {
+		return true
+	}
+	return api.LoadBalancer.Type != kops.LoadBalancerTypeInternal
+}
+
+// Authentication returns the cluster's authentication spec,
+// or an empty struct when the spec field is nil
+func (tf *TemplateFunctions) Authentication() *kops.AuthenticationSpec {
+	if tf.cluster.Spec.Authentication == nil {
+		return &kops.AuthenticationSpec{}
+	}
+	return tf.cluster.Spec.Authentication
+}
+
+// UseKopeioAuthentication returns true if the cluster uses kopeio authentication
+func (tf *TemplateFunctions) UseKopeioAuthentication() bool {
+	return tf.Authentication().Kopeio != nil
+}
+
+// AuthorizationMode returns the effective apiserver authorization mode.
+// It prefers the rendered kube-apiserver flag when set, and otherwise
+// derives the mode from spec.authorization, treating a nil or empty
+// authorization block as AlwaysAllow for backwards compatibility.
+func (tf *TemplateFunctions) AuthorizationMode() string {
+	if tf.cluster.Spec.KubeAPIServer != nil && tf.cluster.Spec.KubeAPIServer.AuthorizationMode != nil {
+		return *tf.cluster.Spec.KubeAPIServer.AuthorizationMode
+	}
+
+	authorization := tf.cluster.Spec.Authorization
+	if authorization == nil || authorization.IsEmpty() || authorization.AlwaysAllow != nil {
+		return "AlwaysAllow"
+	}
+	if authorization.RBAC != nil {
+		return "RBAC"
+	}
+	return "AlwaysAllow"
+}
+
+// UseRBAC returns true if the cluster authorization mode includes RBAC
+func (tf *TemplateFunctions) UseRBAC() bool {
+	for _, mode := range strings.Split(tf.AuthorizationMode(), ",") {
+		if mode == "RBAC" {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkingProvider returns the canonical lowercase name of the
+// networking provider selected in the cluster spec, erroring when
+// zero or more than one provider block is set
+func (tf *TemplateFunctions) NetworkingProvider() (string, error) {
+	networking := tf.cluster.Spec.Networking
+	if networking == nil {
+		return "", fmt.Errorf("spec.networking is not set")
+	}
+
+	var names []string
+	for name, set := range map[string]bool{
+		"classic":    networking.Classic != nil,
+		"kubenet":    networking.Kubenet != nil,
+		"external":   networking.External != nil,
+		"cni":        networking.CNI != nil,
+		"kopeio":     networking.Kopeio != nil,
+		"weave":      networking.Weave != nil,
+		"flannel":    networking.Flannel != nil,
+		"calico":     networking.Calico != nil,
+		"canal":      networking.Canal != nil,
+		"kuberouter": networking.Kuberouter != nil,
+		"romana":     networking.Romana != nil,
+		"amazonvpc":  networking.AmazonVPC != nil,
+		"cilium":     networking.Cilium != nil,
+		"lyftvpc":    networking.LyftVPC != nil,
+		"gce":        networking.GCE != nil,
+	} {
+		if set {
+			names = append(names, name)
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("spec.networking does not select a provider")
+	case 1:
+		return names[0], nil
+	default:
+		sort.Strings(names)
+		return "", fmt.Errorf("spec.networking selects multiple providers: %s", strings.Join(names, ", "))
+	}
+}
+
+// UsesCNI returns true unless the cluster uses classic or kubenet networking
+func (tf *TemplateFunctions) UsesCNI() bool {
+	provider, err := tf.NetworkingProvider()
+	if err != nil {
+		return false
 	}
+	return provider != "classic" && provider != "kubenet"
+}
 
-	return string(encoded)
+// KubeletConfig returns the cluster's kubelet config,
+// following the same nil-safety as KubeAPIServer
+func (tf *TemplateFunctions) KubeletConfig() *kops.KubeletConfigSpec {
+	if tf.cluster.Spec.Kubelet == nil {
+		return &kops.KubeletConfigSpec{}
+	}
+	return tf.cluster.Spec.Kubelet
+}
+
+// CloudProvider returns the normalized cloud provider ID for the cluster,
+// erroring on unknown values so that typos do not silently render empty
+// template blocks
+func (tf *TemplateFunctions) CloudProvider() (kops.CloudProviderID, error) {
+	provider := kops.CloudProviderID(tf.cluster.Spec.CloudProvider)
+	switch provider {
+	case kops.CloudProviderALI, kops.CloudProviderAWS, kops.CloudProviderAzure,
+		kops.CloudProviderBareMetal, kops.CloudProviderDO, kops.CloudProviderGCE,
+		kops.CloudProviderOpenstack, kops.CloudProviderVSphere:
+		return provider, nil
+	}
+	return "", fmt.Errorf("unknown cloudprovider %q", tf.cluster.Spec.CloudProvider)
 }
 
-// EtcdScheme parses and grabs the protocol to the etcd cluster
-func (tf *TemplateFunctions) EtcdScheme() string {
-	if tf.modelContext.UseEtcdTLS() {
-		return "https"
+// Project returns the cloud project for the cluster.  On GCE an empty
+// project is an error, so misconfiguration fails at update time rather
+// than crash-looping external-dns; other clouds return the empty string
+func (tf *TemplateFunctions) Project() (string, error) {
+	if tf.cluster.Spec.Project == "" && tf.IsGCE() {
+		return "", fmt.Errorf("spec.project is required for gce clusters")
 	}
+	return tf.cluster.Spec.Project, nil
+}
+
+// IsAWS returns true if the cluster runs on AWS
+func (tf *TemplateFunctions) IsAWS() bool {
+	return kops.CloudProviderID(tf.cluster.Spec.CloudProvider) == kops.CloudProviderAWS
+}
+
+// IsGCE returns true if the cluster runs on GCE
+func (tf *TemplateFunctions) IsGCE() bool {
+	return kops.CloudProviderID(tf.cluster.Spec.CloudProvider) == kops.CloudProviderGCE
+}
+
+// IsVSphere returns true if the cluster runs on vSphere
+func (tf *TemplateFunctions) IsVSphere() bool {
+	return kops.CloudProviderID(tf.cluster.Spec.CloudProvider) == kops.CloudProviderVSphere
+}
 
-	return "http"
+// ContainerRuntime returns the container runtime configured for the cluster
+func (tf *TemplateFunctions) ContainerRuntime() string {
+	return tf.cluster.Spec.ContainerRuntime
+}
+
+// IsContainerRuntime returns true if the cluster uses the specified
+// container runtime
+func (tf *TemplateFunctions) IsContainerRuntime(rt string) bool {
+	return tf.cluster.Spec.ContainerRuntime == rt
+}
+
+// Docker returns the cluster's Docker daemon config,
+// following the same nil-safety as KubeAPIServer
+func (tf *TemplateFunctions) Docker() *kops.DockerConfig {
+	if tf.cluster.Spec.Docker == nil {
+		return &kops.DockerConfig{}
+	}
+	return tf.cluster.Spec.Docker
+}
+
+// MasterKubeletConfig returns the kubelet config for master nodes,
+// or an empty struct when the spec field is nil.  Templates building
+// master manifests should call this and fall back to KubeletConfig for
+// non-master nodes; fields unset here do not inherit from the
+// cluster-wide kubelet config (use KubeletConfigForInstanceGroup for
+// the merged view).
+func (tf *TemplateFunctions) MasterKubeletConfig() *kops.KubeletConfigSpec {
+	if tf.cluster.Spec.MasterKubelet == nil {
+		return &kops.KubeletConfigSpec{}
+	}
+	return tf.cluster.Spec.MasterKubelet
+}
+
+// CloudConfig returns the cluster's cloud configuration,
+// or an empty struct when the spec field is nil
+func (tf *TemplateFunctions) CloudConfig() *kops.CloudConfiguration {
+	if tf.cluster.Spec.CloudConfig == nil {
+		return &kops.CloudConfiguration{}
+	}
+	return tf.cluster.Spec.CloudConfig
+}
+
+// KubeletConfigForInstanceGroup returns the effective kubelet config for the
+// named instance group, merging the IG overrides on top of the cluster-level
+// config; masters merge against MasterKubelet instead of Kubelet
+func (tf *TemplateFunctions) KubeletConfigForInstanceGroup(name string) (*kops.KubeletConfigSpec, error) {
+	ig, err := tf.GetInstanceGroup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &kops.KubeletConfigSpec{}
+	if ig.IsMaster() {
+		if tf.cluster.Spec.MasterKubelet != nil {
+			reflectutils.JsonMergeStruct(c, tf.cluster.Spec.MasterKubelet)
+		}
+	} else {
+		if tf.cluster.Spec.Kubelet != nil {
+			reflectutils.JsonMergeStruct(c, tf.cluster.Spec.Kubelet)
+		}
+	}
+	if ig.Spec.Kubelet != nil {
+		reflectutils.JsonMergeStruct(c, ig.Spec.Kubelet)
+	}
+	return c, nil
+}
+
+// KubeProxy returns the cluster's KubeProxy config,
+// following the same nil-safety as KubeAPIServer
+func (tf *TemplateFunctions) KubeProxy() *kops.KubeProxyConfig {
+	if tf.cluster.Spec.KubeProxy == nil {
+		return &kops.KubeProxyConfig{}
+	}
+	return tf.cluster.Spec.KubeProxy
+}
+
+// KubeProxyEnabled returns true unless kube-proxy is explicitly disabled or
+// the selected networking provider replaces it
+func (tf *TemplateFunctions) KubeProxyEnabled() bool {
+	if tf.cluster.Spec.KubeProxy != nil && tf.cluster.Spec.KubeProxy.Enabled != nil {
+		return *tf.cluster.Spec.KubeProxy.Enabled
+	}
+	if tf.cluster.Spec.Networking != nil && tf.cluster.Spec.Networking.Kuberouter != nil {
+		return false
+	}
+	return true
+}
+
+// MasterInternalName returns the internal apiserver endpoint name
+func (tf *TemplateFunctions) MasterInternalName() string {
+	return tf.cluster.Spec.MasterInternalName
+}
+
+// MasterPublicName returns the public apiserver endpoint name
+func (tf *TemplateFunctions) MasterPublicName() string {
+	return tf.cluster.Spec.MasterPublicName
+}
+
+// NetworkCIDR returns the cluster network CIDR; an empty value fails the
+// render rather than producing manifests with a blank CIDR
+func (tf *TemplateFunctions) NetworkCIDR() (string, error) {
+	if tf.cluster.Spec.NetworkCIDR == "" {
+		return "", fmt.Errorf("spec.networkCIDR is not set")
+	}
+	return tf.cluster.Spec.NetworkCIDR, nil
+}
+
+// NonMasqueradeCIDR returns the cluster non-masquerade CIDR; an empty value
+// fails the render rather than producing manifests with a blank CIDR
+func (tf *TemplateFunctions) NonMasqueradeCIDR() (string, error) {
+	if tf.cluster.Spec.NonMasqueradeCIDR == "" {
+		return "", fmt.Errorf("spec.nonMasqueradeCIDR is not set")
+	}
+	return tf.cluster.Spec.NonMasqueradeCIDR, nil
+}
+
+// EtcdClusters returns the etcd clusters in the spec, sorted by name so that
+// rendered manifests are deterministic
+func (tf *TemplateFunctions) EtcdClusters() []*kops.EtcdClusterSpec {
+	clusters := make([]*kops.EtcdClusterSpec, len(tf.cluster.Spec.EtcdClusters))
+	copy(clusters, tf.cluster.Spec.EtcdClusters)
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+	return clusters
+}
+
+// GetEtcdCluster returns the etcd cluster with the specified name (typically
+// "main" or "events")
+func (tf *TemplateFunctions) GetEtcdCluster(name string) (*kops.EtcdClusterSpec, error) {
+	var names []string
+	for _, etcd := range tf.cluster.Spec.EtcdClusters {
+		if etcd.Name == name {
+			return etcd, nil
+		}
+		names = append(names, etcd.Name)
+	}
+	return nil, fmt.Errorf("etcd cluster %q not found; available clusters are %s", name, strings.Join(names, ", "))
+}
+
+// HasFeatureGate returns true when the named feature gate is enabled on the
+// given component; a gate explicitly set to "false" reports false
+func (tf *TemplateFunctions) HasFeatureGate(component, gate string) (bool, error) {
+	var gates map[string]string
+	spec := &tf.cluster.Spec
+	switch component {
+	case "kubelet":
+		if spec.Kubelet != nil {
+			gates = spec.Kubelet.FeatureGates
+		}
+	case "kubeAPIServer":
+		if spec.KubeAPIServer != nil {
+			gates = spec.KubeAPIServer.FeatureGates
+		}
+	case "kubeControllerManager":
+		if spec.KubeControllerManager != nil {
+			gates = spec.KubeControllerManager.FeatureGates
+		}
+	case "kubeScheduler":
+		if spec.KubeScheduler != nil {
+			gates = spec.KubeScheduler.FeatureGates
+		}
+	default:
+		return false, fmt.Errorf("unknown component %q; expected one of kubelet, kubeAPIServer, kubeControllerManager, kubeScheduler", component)
+	}
+	return gates[gate] == "true", nil
+}
+
+// MasterCount returns the total expected number of masters, summing MinSize
+// across the master instance groups; an IG without a MinSize is an error
+func (tf *TemplateFunctions) MasterCount() (int, error) {
+	count := 0
+	for _, ig := range tf.instanceGroupsForRole(kops.InstanceGroupRoleMaster) {
+		if ig.Spec.MinSize == nil {
+			return 0, fmt.Errorf("instance group %q does not have a MinSize", ig.ObjectMeta.Name)
+		}
+		count += int(*ig.Spec.MinSize)
+	}
+	return count, nil
+}
+
+// NodeCount returns the total expected number of nodes, summing MinSize
+// across the node instance groups; an IG without a MinSize is an error
+func (tf *TemplateFunctions) NodeCount() (int, error) {
+	count := 0
+	for _, ig := range tf.instanceGroupsForRole(kops.InstanceGroupRoleNode) {
+		if ig.Spec.MinSize == nil {
+			return 0, fmt.Errorf("instance group %q does not have a MinSize", ig.ObjectMeta.Name)
+		}
+		count += int(*ig.Spec.MinSize)
+	}
+	return count, nil
+}
+
+// IsHighlyAvailable returns true if the cluster has three or more masters,
+// so templates can enable leader election and multi-AZ load balancing
+func (tf *TemplateFunctions) IsHighlyAvailable() bool {
+	count := int32(0)
+	for _, ig := range tf.instanceGroupsForRole(kops.InstanceGroupRoleMaster) {
+		if ig.Spec.MinSize != nil {
+			count += *ig.Spec.MinSize
+		} else {
+			count++
+		}
+	}
+	return count >= 3
+}
+
+// accessCIDRList validates and deduplicates an admin access CIDR list,
+// defaulting to 0.0.0.0/0 when unset to preserve the current open default
+func accessCIDRList(field string, values []string) ([]string, error) {
+	if len(values) == 0 {
+		return []string{"0.0.0.0/0"}, nil
+	}
+	var cidrs []string
+	seen := sets.NewString()
+	for _, v := range values {
+		if _, _, err := net.ParseCIDR(v); err != nil {
+			return nil, fmt.Errorf("cannot parse %s entry %q as a CIDR: %v", field, v, err)
+		}
+		if seen.Has(v) {
+			continue
+		}
+		seen.Insert(v)
+		cidrs = append(cidrs, v)
+	}
+	return cidrs, nil
+}
+
+// KubernetesAPIAccess returns the CIDRs that may reach the apiserver
+func (tf *TemplateFunctions) KubernetesAPIAccess() ([]string, error) {
+	return accessCIDRList("spec.kubernetesApiAccess", tf.cluster.Spec.KubernetesAPIAccess)
+}
+
+// SSHAccess returns the CIDRs that may reach the nodes over SSH
+func (tf *TemplateFunctions) SSHAccess() ([]string, error) {
+	return accessCIDRList("spec.sshAccess", tf.cluster.Spec.SSHAccess)
+}
+
+// APIServerURL returns the URL clients inside the cluster use to reach the
+// apiserver, built from the master internal name and any non-default
+// secure port
+func (tf *TemplateFunctions) APIServerURL() string {
+	host := tf.cluster.Spec.MasterInternalName
+	if tf.cluster.Spec.KubeAPIServer != nil && tf.cluster.Spec.KubeAPIServer.SecurePort != 0 && tf.cluster.Spec.KubeAPIServer.SecurePort != 443 {
+		return fmt.Sprintf("https://%s:%d", host, tf.cluster.Spec.KubeAPIServer.SecurePort)
+	}
+	return "https://" + host
+}
+
+// EtcdMemberCount returns the number of members in the named etcd cluster
+func (tf *TemplateFunctions) EtcdMemberCount(clusterName string) (int, error) {
+	etcd, err := tf.GetEtcdCluster(clusterName)
+	if err != nil {
+		return 0, err
+	}
+	return len(etcd.Members), nil
+}
+
+// EtcdVersion returns the etcd version of the named etcd cluster,
+// erroring when the version is empty rather than letting templates emit
+// an untagged image reference
+func (tf *TemplateFunctions) EtcdVersion(clusterName string) (string, error) {
+	etcd, err := tf.GetEtcdCluster(clusterName)
+	if err != nil {
+		return "", err
+	}
+	if etcd.Version == "" {
+		return "", fmt.Errorf("etcd cluster %q does not have a version set", clusterName)
+	}
+	return etcd.Version, nil
+}
+
+// EtcdQuorumSize returns the number of members that must be healthy for the
+// named etcd cluster to have quorum; a single-member cluster has quorum 1
+func (tf *TemplateFunctions) EtcdQuorumSize(clusterName string) (int, error) {
+	count, err := tf.EtcdMemberCount(clusterName)
+	if err != nil {
+		return 0, err
+	}
+	return count/2 + 1, nil
+}
+
+// ClusterDNSIP returns the IP of the cluster DNS service, honoring an
+// explicit KubeDNS.ServerIP override and otherwise using the conventional
+// 10th address of the service cluster IP range
+func (tf *TemplateFunctions) ClusterDNSIP() (string, error) {
+	if tf.cluster.Spec.KubeDNS != nil && tf.cluster.Spec.KubeDNS.ServerIP != "" {
+		return tf.cluster.Spec.KubeDNS.ServerIP, nil
+	}
+	_, cidr, err := net.ParseCIDR(tf.cluster.Spec.ServiceClusterIPRange)
+	if err != nil {
+		return "", fmt.Errorf("error parsing spec.serviceClusterIPRange %q: %v", tf.cluster.Spec.ServiceClusterIPRange, err)
+	}
+	// The range must hold at least the well-known addresses up to index 10
+	if ones, bits := cidr.Mask.Size(); bits-ones < 4 {
+		return "", fmt.Errorf("spec.serviceClusterIPRange %q is too small to hold the cluster DNS IP", tf.cluster.Spec.ServiceClusterIPRange)
+	}
+	ip, err := components.WellKnownServiceIP(&tf.cluster.Spec, 10)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// PodCIDR returns the CIDR pods are assigned addresses from, preferring an
+// explicit KubeControllerManager.ClusterCIDR over the NonMasqueradeCIDR
+func (tf *TemplateFunctions) PodCIDR() (string, error) {
+	if tf.cluster.Spec.KubeControllerManager != nil && tf.cluster.Spec.KubeControllerManager.ClusterCIDR != "" {
+		return tf.cluster.Spec.KubeControllerManager.ClusterCIDR, nil
+	}
+	if tf.cluster.Spec.NonMasqueradeCIDR == "" {
+		return "", fmt.Errorf("pod CIDR is not set: neither spec.kubeControllerManager.clusterCIDR nor spec.nonMasqueradeCIDR has a value")
+	}
+	return tf.cluster.Spec.NonMasqueradeCIDR, nil
+}
+
+// IsGossipCluster returns true if the cluster uses gossip DNS, so templates
+// can branch without embedding knowledge about gossip hostnames
+func (tf *TemplateFunctions) IsGossipCluster() bool {
+	return dns.IsGossipHostname(tf.cluster.Spec.MasterInternalName)
+}
+
+// Base64Gzip gzips and then base64-encodes its input, for embedding large
+// files in size-limited user data; the output is deterministic across runs
+func (tf *TemplateFunctions) Base64Gzip(s string) (string, error) {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", fmt.Errorf("error creating gzip writer: %v", err)
+	}
+	if _, err := gz.Write([]byte(s)); err != nil {
+		return "", fmt.Errorf("error gzipping data: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("error gzipping data: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
 }
 
 // SharedVPC is a simple helper function which makes the templates for a shared VPC clearer
@@ -193,6 +1318,215 @@ func (tf *TemplateFunctions) GetInstanceGroup(name string) (*kops.InstanceGroup,
 	return nil, fmt.Errorf("InstanceGroup %q not found", name)
 }
 
+// GetMasterInstanceGroups returns the instance groups with the master role,
+// sorted by name for deterministic template output
+func (tf *TemplateFunctions) GetMasterInstanceGroups() ([]*kops.InstanceGroup, error) {
+	return tf.instanceGroupsForRole(kops.InstanceGroupRoleMaster), nil
+}
+
+// GetNodeInstanceGroups returns the instance groups with the node role, sorted
+// by name; masters and bastions are excluded
+func (tf *TemplateFunctions) GetNodeInstanceGroups() ([]*kops.InstanceGroup, error) {
+	return tf.instanceGroupsForRole(kops.InstanceGroupRoleNode), nil
+}
+
+// GetBastionInstanceGroups returns the instance groups with the bastion role,
+// sorted by name; a cluster without bastions yields an empty slice, not an error
+func (tf *TemplateFunctions) GetBastionInstanceGroups() ([]*kops.InstanceGroup, error) {
+	return tf.instanceGroupsForRole(kops.InstanceGroupRoleBastion), nil
+}
+
+// instanceGroupsForRole returns the instance groups with the specified role, sorted by name
+func (tf *TemplateFunctions) instanceGroupsForRole(role kops.InstanceGroupRole) []*kops.InstanceGroup {
+	groups := []*kops.InstanceGroup{}
+	for _, ig := range tf.instanceGroups {
+		if ig.Spec.Role == role {
+			groups = append(groups, ig)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ObjectMeta.Name < groups[j].ObjectMeta.Name
+	})
+	return groups
+}
+
+// KubernetesVersionAtLeast returns true if the cluster's Kubernetes version is at least v,
+// so templates can gate version-specific flags and image tags
+func (tf *TemplateFunctions) KubernetesVersionAtLeast(v string) (bool, error) {
+	required, err := util.ParseKubernetesVersion(v)
+	if err != nil {
+		return false, fmt.Errorf("error parsing version %q: %v", v, err)
+	}
+	current, err := util.ParseKubernetesVersion(tf.cluster.Spec.KubernetesVersion)
+	if err != nil {
+		return false, fmt.Errorf("error parsing cluster kubernetes version %q: %v", tf.cluster.Spec.KubernetesVersion, err)
+	}
+
+	// Ignore Pre & Build fields
+	current.Pre = nil
+	current.Build = nil
+
+	return current.GTE(*required), nil
+}
+
+// GetSubnet returns the cluster subnet with the specified name
+func (tf *TemplateFunctions) GetSubnet(name string) (*kops.ClusterSubnetSpec, error) {
+	var found *kops.ClusterSubnetSpec
+	var names []string
+	for i := range tf.cluster.Spec.Subnets {
+		subnet := &tf.cluster.Spec.Subnets[i]
+		names = append(names, subnet.Name)
+		if subnet.Name == name {
+			if found != nil {
+				return nil, fmt.Errorf("found multiple subnets with name %q", name)
+			}
+			found = subnet
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("subnet %q not found; available subnets are %s", name, strings.Join(names, ", "))
+	}
+	return found, nil
+}
+
+// cniAsset resolves the CNI plugin bundle for the cluster's kubernetes
+// version, honoring the assets.fileRepository override in the spec
+func (tf *TemplateFunctions) cniAsset() (*url.URL, *hashing.Hash, error) {
+	if _, err := util.ParseKubernetesVersion(tf.cluster.Spec.KubernetesVersion); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse kubernetes version %q", tf.cluster.Spec.KubernetesVersion)
+	}
+	assetBuilder := assets.NewAssetBuilder(tf.cluster, "")
+	return findCNIAssets(tf.cluster, assetBuilder)
+}
+
+// CNIAssetURL returns the URL of the CNI plugin tarball for the cluster's
+// kubernetes version
+func (tf *TemplateFunctions) CNIAssetURL() (string, error) {
+	u, _, err := tf.cniAsset()
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// CNIAssetHash returns the hash nodes use to verify the CNI plugin tarball
+func (tf *TemplateFunctions) CNIAssetHash() (string, error) {
+	_, hash, err := tf.cniAsset()
+	if err != nil {
+		return "", err
+	}
+	if hash == nil {
+		return "", fmt.Errorf("hash is not known for CNI asset")
+	}
+	return hash.Hex(), nil
+}
+
+// InstanceGroupMachineType returns the machine type of the named instance
+// group, erroring when the IG does not exist or does not set one
+func (tf *TemplateFunctions) InstanceGroupMachineType(igName string) (string, error) {
+	ig, err := tf.GetInstanceGroup(igName)
+	if err != nil {
+		return "", err
+	}
+	if ig.Spec.MachineType == "" {
+		return "", fmt.Errorf("InstanceGroup %q does not have a machine type set", igName)
+	}
+	return ig.Spec.MachineType, nil
+}
+
+// InstanceGroupImage returns the image for the named instance group,
+// falling back to the cloudprovider default when the IG does not set one
+func (tf *TemplateFunctions) InstanceGroupImage(igName string) (string, error) {
+	ig, err := tf.GetInstanceGroup(igName)
+	if err != nil {
+		return "", err
+	}
+	if ig.Spec.Image != "" {
+		return ig.Spec.Image, nil
+	}
+	if image := defaultImage(tf.cluster, nil); image != "" {
+		return image, nil
+	}
+	return "", fmt.Errorf("unable to determine image for InstanceGroup %q", igName)
+}
+
+// InstanceGroupMinSize returns the minimum size of the named instance
+// group, erroring when it is not set
+func (tf *TemplateFunctions) InstanceGroupMinSize(igName string) (int32, error) {
+	ig, err := tf.GetInstanceGroup(igName)
+	if err != nil {
+		return 0, err
+	}
+	if ig.Spec.MinSize == nil {
+		return 0, fmt.Errorf("InstanceGroup %q does not have a minimum size set", igName)
+	}
+	return *ig.Spec.MinSize, nil
+}
+
+// InstanceGroupMaxSize returns the maximum size of the named instance
+// group, erroring when it is not set
+func (tf *TemplateFunctions) InstanceGroupMaxSize(igName string) (int32, error) {
+	ig, err := tf.GetInstanceGroup(igName)
+	if err != nil {
+		return 0, err
+	}
+	if ig.Spec.MaxSize == nil {
+		return 0, fmt.Errorf("InstanceGroup %q does not have a maximum size set", igName)
+	}
+	return *ig.Spec.MaxSize, nil
+}
+
+// InstanceGroupSubnets resolves the named instance group's subnets to
+// the full cluster subnet specs
+func (tf *TemplateFunctions) InstanceGroupSubnets(igName string) ([]*kops.ClusterSubnetSpec, error) {
+	ig, err := tf.GetInstanceGroup(igName)
+	if err != nil {
+		return nil, err
+	}
+
+	subnets := []*kops.ClusterSubnetSpec{}
+	for _, name := range ig.Spec.Subnets {
+		subnet, err := tf.GetSubnet(name)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// GetSubnetsForRole returns the cluster subnets with the specified type (Public, Private or Utility)
+func (tf *TemplateFunctions) GetSubnetsForRole(role string) ([]*kops.ClusterSubnetSpec, error) {
+	switch kops.SubnetType(role) {
+	case kops.SubnetTypePublic, kops.SubnetTypePrivate, kops.SubnetTypeUtility:
+	// ok
+	default:
+		return nil, fmt.Errorf("unknown subnet role %q", role)
+	}
+
+	subnets := []*kops.ClusterSubnetSpec{}
+	for i := range tf.cluster.Spec.Subnets {
+		subnet := &tf.cluster.Spec.Subnets[i]
+		if subnet.Type == kops.SubnetType(role) {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets, nil
+}
+
+// SubnetsOfType returns the cluster subnets with the specified type, sorted by
+// name; an unknown type is an error so that typos in models are caught
+func (tf *TemplateFunctions) SubnetsOfType(t string) ([]*kops.ClusterSubnetSpec, error) {
+	subnets, err := tf.GetSubnetsForRole(t)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(subnets, func(i, j int) bool {
+		return subnets[i].Name < subnets[j].Name
+	})
+	return subnets, nil
+}
+
 // CloudControllerConfigArgv returns the args to external cloud controller
 func (tf *TemplateFunctions) CloudControllerConfigArgv() ([]string, error) {
 	if tf.cluster.Spec.ExternalCloudControllerManager == nil {
@@ -239,6 +1573,63 @@ func (tf *TemplateFunctions) CloudControllerConfigArgv() ([]string, error) {
 	return argv, nil
 }
 
+// UseEtcdTLS returns true if the etcd clusters are configured for TLS,
+// erroring when the clusters disagree rather than picking one silently
+func (tf *TemplateFunctions) UseEtcdTLS() (bool, error) {
+	var useTLS bool
+	for i, cluster := range tf.cluster.Spec.EtcdClusters {
+		if i == 0 {
+			useTLS = cluster.EnableEtcdTLS
+		} else if cluster.EnableEtcdTLS != useTLS {
+			return false, fmt.Errorf("etcd clusters disagree on TLS; enableEtcdTLS must be set consistently")
+		}
+	}
+	return useTLS, nil
+}
+
+// EtcdScheme returns the URL scheme (http or https) the etcd clusters use
+func (tf *TemplateFunctions) EtcdScheme() (string, error) {
+	useTLS, err := tf.UseEtcdTLS()
+	if err != nil {
+		return "", err
+	}
+	if useTLS {
+		return "https", nil
+	}
+	return "http", nil
+}
+
+// IsInternalDNS returns true if the cluster topology publishes DNS
+// records into a private hosted zone
+func (tf *TemplateFunctions) IsInternalDNS() bool {
+	topology := tf.cluster.Spec.Topology
+	if topology != nil && topology.DNS != nil {
+		return topology.DNS.Type == kops.DNSTypePrivate
+	}
+	return false
+}
+
+// GossipSeeds returns the gossip seed addresses for the cluster,
+// defaulting to the local protokube mesh when the spec does not
+// configure explicit seeds
+func (tf *TemplateFunctions) GossipSeeds() ([]string, error) {
+	if !dns.IsGossipHostname(tf.cluster.Spec.MasterInternalName) {
+		return nil, fmt.Errorf("cluster is not using gossip DNS")
+	}
+
+	var seeds []string
+	gossipConfig := tf.cluster.Spec.DNSControllerGossipConfig
+	if gossipConfig != nil && gossipConfig.Seed != nil {
+		seeds = append(seeds, *gossipConfig.Seed)
+	} else {
+		seeds = append(seeds, fmt.Sprintf("127.0.0.1:%d", wellknownports.ProtokubeGossipWeaveMesh))
+	}
+	if gossipConfig != nil && gossipConfig.Secondary != nil && gossipConfig.Secondary.Seed != nil {
+		seeds = append(seeds, *gossipConfig.Secondary.Seed)
+	}
+	return seeds, nil
+}
+
 // DnsControllerArgv returns the args to the DNS controller
 func (tf *TemplateFunctions) DnsControllerArgv() ([]string, error) {
 	var argv []string
@@ -314,7 +1705,12 @@ func (tf *TemplateFunctions) DnsControllerArgv() ([]string, error) {
 			argv = append(argv, fmt.Sprintf("--gossip-seed-secondary=127.0.0.1:%d", wellknownports.ProtokubeGossipMemberlist))
 		}
 	} else {
-		switch kops.CloudProviderID(tf.cluster.Spec.CloudProvider) {
+		cloudProvider, err := tf.CloudProvider()
+		if err != nil {
+			return nil, err
+		}
+
+		switch cloudProvider {
 		case kops.CloudProviderAWS:
 			if strings.HasPrefix(os.Getenv("AWS_REGION"), "cn-") {
 				argv = append(argv, "--dns=gossip")
@@ -325,13 +1721,30 @@ func (tf *TemplateFunctions) DnsControllerArgv() ([]string, error) {
 			argv = append(argv, "--dns=google-clouddns")
 		case kops.CloudProviderDO:
 			argv = append(argv, "--dns=digitalocean")
+		case kops.CloudProviderAzure:
+			// dns-controller does not have an azure dnsprovider yet
+			return nil, fmt.Errorf("dns-controller does not support azure; use gossip DNS for azure clusters")
+		case kops.CloudProviderOpenstack:
+			if tf.cluster.Spec.CloudConfig == nil || tf.cluster.Spec.CloudConfig.Openstack == nil {
+				return nil, fmt.Errorf("spec.cloudConfig.openstack is required for openstack clusters")
+			}
+			argv = append(argv, "--dns=openstack-designate")
 		case kops.CloudProviderVSphere:
+			if tf.cluster.Spec.CloudConfig == nil || tf.cluster.Spec.CloudConfig.VSphereCoreDNSServer == nil {
+				return nil, fmt.Errorf("spec.cloudConfig.vSphereCoreDNSServer is required for vsphere clusters")
+			}
 			argv = append(argv, "--dns=coredns")
 			argv = append(argv, "--dns-server="+*tf.cluster.Spec.CloudConfig.VSphereCoreDNSServer)
 
 		default:
 			return nil, fmt.Errorf("unhandled cloudprovider %q", tf.cluster.Spec.CloudProvider)
 		}
+
+		// For a private DNS topology we restrict dns-controller to the
+		// configured zone, so we must know which zone that is
+		if tf.IsInternalDNS() && tf.cluster.Spec.DNSZone == "" {
+			return nil, fmt.Errorf("spec.dnsZone is required for a private DNS topology")
+		}
 	}
 
 	zone := tf.cluster.Spec.DNSZone
@@ -344,8 +1757,11 @@ func (tf *TemplateFunctions) DnsControllerArgv() ([]string, error) {
 			argv = append(argv, "--zone=*/"+zone)
 		}
 	}
-	// permit wildcard updates
-	argv = append(argv, "--zone=*/*")
+	if !tf.IsInternalDNS() {
+		// permit wildcard updates; a private DNS topology only publishes
+		// into the configured zone
+		argv = append(argv, "--zone=*/*")
+	}
 	// Verbose, but not crazy logging
 	argv = append(argv, "-v=2")
 
@@ -386,15 +1802,36 @@ func (tf *TemplateFunctions) KopsControllerArgv() ([]string, error) {
 func (tf *TemplateFunctions) ExternalDnsArgv() ([]string, error) {
 	var argv []string
 
-	cloudProvider := tf.cluster.Spec.CloudProvider
+	cloudProvider, err := tf.CloudProvider()
+	if err != nil {
+		return nil, err
+	}
 
-	switch kops.CloudProviderID(cloudProvider) {
+	switch cloudProvider {
 	case kops.CloudProviderAWS:
 		argv = append(argv, "--provider=aws")
 	case kops.CloudProviderGCE:
-		project := tf.cluster.Spec.Project
+		project, err := tf.Project()
+		if err != nil {
+			return nil, err
+		}
 		argv = append(argv, "--provider=google")
 		argv = append(argv, "--google-project="+project)
+	case kops.CloudProviderOpenstack:
+		if tf.cluster.Spec.CloudConfig == nil || tf.cluster.Spec.CloudConfig.Openstack == nil {
+			return nil, fmt.Errorf("spec.cloudConfig.openstack is required for openstack clusters")
+		}
+		argv = append(argv, "--provider=designate")
+	case kops.CloudProviderAzure:
+		argv = append(argv, "--provider=azure")
+		if tf.cluster.Spec.CloudConfig != nil {
+			if subscriptionID := fi.StringValue(tf.cluster.Spec.CloudConfig.AzureSubscriptionID); subscriptionID != "" {
+				argv = append(argv, "--azure-subscription-id="+subscriptionID)
+			}
+			if resourceGroup := fi.StringValue(tf.cluster.Spec.CloudConfig.AzureResourceGroup); resourceGroup != "" {
+				argv = append(argv, "--azure-resource-group="+resourceGroup)
+			}
+		}
 	default:
 		return nil, fmt.Errorf("unhandled cloudprovider %q", tf.cluster.Spec.CloudProvider)
 	}
@@ -429,6 +1866,44 @@ func (tf *TemplateFunctions) ProxyEnv() map[string]string {
 	return envs
 }
 
+// NoProxy computes the NO_PROXY list for the cluster: the in-cluster
+// CIDRs and names that must never go through the egress proxy, merged
+// with any excludes from the spec.  The ordering is deterministic so
+// that generated manifests do not churn between runs.
+func (tf *TemplateFunctions) NoProxy() string {
+	var entries []string
+
+	if tf.cluster.Spec.MasterInternalName != "" {
+		entries = append(entries, tf.cluster.Spec.MasterInternalName)
+	}
+	entries = append(entries, ".cluster.local")
+	if tf.cluster.Spec.ServiceClusterIPRange != "" {
+		entries = append(entries, tf.cluster.Spec.ServiceClusterIPRange)
+	}
+	if podCIDR, err := tf.PodCIDR(); err == nil {
+		entries = append(entries, podCIDR)
+	}
+	if tf.cluster.Spec.EgressProxy != nil && tf.cluster.Spec.EgressProxy.ProxyExcludes != "" {
+		for _, exclude := range strings.Split(tf.cluster.Spec.EgressProxy.ProxyExcludes, ",") {
+			exclude = strings.TrimSpace(exclude)
+			if exclude != "" {
+				entries = append(entries, exclude)
+			}
+		}
+	}
+
+	seen := sets.NewString()
+	var noProxy []string
+	for _, entry := range entries {
+		if seen.Has(entry) {
+			continue
+		}
+		seen.Insert(entry)
+		noProxy = append(noProxy, entry)
+	}
+	return strings.Join(noProxy, ",")
+}
+
 // KopsSystemEnv builds the env vars for a system component
 func (tf *TemplateFunctions) KopsSystemEnv() []corev1.EnvVar {
 	envMap := env.BuildSystemComponentEnvVars(&tf.cluster.Spec)
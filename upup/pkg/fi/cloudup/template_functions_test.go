@@ -18,6 +18,8 @@ package cloudup
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
@@ -26,9 +28,12 @@ import (
 	"testing"
 	"text/template"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/kops/pkg/apis/kops"
 	"k8s.io/kops/pkg/featureflag"
 	"k8s.io/kops/upup/pkg/fi"
+	"sigs.k8s.io/yaml"
 )
 
 func Test_TemplateFunctions_CloudControllerConfigArgv(t *testing.T) {
@@ -214,6 +219,4317 @@ func Test_TemplateFunctions_CloudControllerConfigArgv(t *testing.T) {
 	}
 }
 
+func Test_TemplateFunctions_DnsControllerArgv(t *testing.T) {
+	tests := []struct {
+		desc          string
+		cluster       *kops.Cluster
+		expectedArgv  []string
+		expectedError error
+	}{
+		{
+			desc: "Azure cluster",
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderAzure),
+			}},
+			expectedError: fmt.Errorf("dns-controller does not support azure; use gossip DNS for azure clusters"),
+		},
+		{
+			desc: "OpenStack cluster",
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderOpenstack),
+				CloudConfig: &kops.CloudConfiguration{
+					Openstack: &kops.OpenstackConfiguration{},
+				},
+			}},
+			expectedArgv: []string{
+				"/usr/bin/dns-controller",
+				"--watch-ingress=false",
+				"--dns=openstack-designate",
+				"--zone=*/*",
+				"-v=2",
+			},
+		},
+		{
+			desc: "OpenStack cluster without openstack cloudConfig",
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderOpenstack),
+			}},
+			expectedError: fmt.Errorf("spec.cloudConfig.openstack is required for openstack clusters"),
+		},
+		{
+			desc: "unhandled cloud provider",
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				CloudProvider: "baremetal",
+			}},
+			expectedError: fmt.Errorf("unhandled cloudprovider %q", "baremetal"),
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: testCase.cluster,
+			}
+			actual, err := tf.DnsControllerArgv()
+			if !reflect.DeepEqual(err, testCase.expectedError) {
+				t.Errorf("Error differs: %+v instead of %+v", err, testCase.expectedError)
+			}
+			if testCase.expectedArgv != nil && !reflect.DeepEqual(actual, testCase.expectedArgv) {
+				t.Errorf("Argv differs: %+v instead of %+v", actual, testCase.expectedArgv)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_ExternalDnsArgv(t *testing.T) {
+	tests := []struct {
+		desc          string
+		cluster       *kops.Cluster
+		expectedArgv  []string
+		expectedError error
+	}{
+		{
+			desc: "AWS cluster",
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderAWS),
+			}},
+			expectedArgv: []string{
+				"--provider=aws",
+				"--source=ingress",
+			},
+		},
+		{
+			desc: "Azure cluster",
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderAzure),
+				CloudConfig: &kops.CloudConfiguration{
+					AzureSubscriptionID: fi.String("00000000-0000-0000-0000-000000000000"),
+					AzureResourceGroup:  fi.String("kops-rg"),
+				},
+			}},
+			expectedArgv: []string{
+				"--provider=azure",
+				"--azure-subscription-id=00000000-0000-0000-0000-000000000000",
+				"--azure-resource-group=kops-rg",
+				"--source=ingress",
+			},
+		},
+		{
+			desc: "OpenStack cluster",
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderOpenstack),
+				CloudConfig: &kops.CloudConfiguration{
+					Openstack: &kops.OpenstackConfiguration{},
+				},
+			}},
+			expectedArgv: []string{
+				"--provider=designate",
+				"--source=ingress",
+			},
+		},
+		{
+			desc: "OpenStack cluster without openstack cloudConfig",
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderOpenstack),
+			}},
+			expectedError: fmt.Errorf("spec.cloudConfig.openstack is required for openstack clusters"),
+		},
+		{
+			desc: "unhandled cloud provider",
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				CloudProvider: "baremetal",
+			}},
+			expectedError: fmt.Errorf("unhandled cloudprovider %q", "baremetal"),
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: testCase.cluster,
+			}
+			actual, err := tf.ExternalDnsArgv()
+			if !reflect.DeepEqual(err, testCase.expectedError) {
+				t.Errorf("Error differs: %+v instead of %+v", err, testCase.expectedError)
+			}
+			if testCase.expectedArgv != nil && !reflect.DeepEqual(actual, testCase.expectedArgv) {
+				t.Errorf("Argv differs: %+v instead of %+v", actual, testCase.expectedArgv)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Base64Decode(t *testing.T) {
+	tests := []struct {
+		desc        string
+		input       string
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "padded encoding",
+			input:    "aGVsbG8gd29ybGQ=",
+			expected: "hello world",
+		},
+		{
+			desc:     "raw std encoding",
+			input:    "aGVsbG8gd29ybGQ",
+			expected: "hello world",
+		},
+		{
+			desc:     "empty string",
+			input:    "",
+			expected: "",
+		},
+		{
+			desc:        "invalid base64",
+			input:       "not!base64",
+			expectError: true,
+		},
+		{
+			desc:        "truncated padding",
+			input:       "aGVsbG8gd29ybGQ==",
+			expectError: true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("decode").Funcs(funcMap).Parse(`{{ Base64Decode .Input }}`))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, map[string]string{"Input": testCase.input})
+			if testCase.expectError {
+				if err == nil {
+					t.Fatalf("expected error decoding %q, got output %q", testCase.input, buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("decoded value differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Arch(t *testing.T) {
+	cluster := &kops.Cluster{}
+	instanceGroups := []*kops.InstanceGroup{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "master-us-test-1a",
+				Annotations: map[string]string{"kops.k8s.io/architecture": "amd64"},
+			},
+			Spec: kops.InstanceGroupSpec{Role: kops.InstanceGroupRoleMaster},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "nodes",
+				Annotations: map[string]string{"kops.k8s.io/architecture": "arm64"},
+			},
+			Spec: kops.InstanceGroupSpec{Role: kops.InstanceGroupRoleNode},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "unannotated"},
+			Spec:       kops.InstanceGroupSpec{Role: kops.InstanceGroupRoleNode},
+		},
+	}
+
+	tests := []struct {
+		desc        string
+		igName      []string
+		expected    string
+		expectError bool
+	}{
+		{desc: "cluster default", expected: "amd64"},
+		{desc: "master instance group", igName: []string{"master-us-test-1a"}, expected: "amd64"},
+		{desc: "arm64 node instance group", igName: []string{"nodes"}, expected: "arm64"},
+		{desc: "instance group without annotation", igName: []string{"unannotated"}, expected: "amd64"},
+		{desc: "unknown instance group", igName: []string{"missing"}, expectError: true},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster:        cluster,
+				instanceGroups: instanceGroups,
+			}
+			actual, err := tf.Arch(testCase.igName...)
+			if testCase.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got %q", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != testCase.expected {
+				t.Errorf("Arch differs: %q instead of %q", actual, testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Indent(t *testing.T) {
+	pem := "-----BEGIN CERTIFICATE-----\nMIIB\tfake\n-----END CERTIFICATE-----"
+
+	tests := []struct {
+		desc     string
+		template string
+		cert     string
+		expected string
+	}{
+		{
+			desc:     "indent inside a YAML value",
+			template: "data:\n  cert: |\n{{ indent 4 .Cert }}",
+			expected: "data:\n  cert: |\n    -----BEGIN CERTIFICATE-----\n    MIIB\tfake\n    -----END CERTIFICATE-----",
+		},
+		{
+			desc:     "nindent prepends a newline",
+			template: "data:\n  cert: |{{ nindent 4 .Cert }}",
+			expected: "data:\n  cert: |\n    -----BEGIN CERTIFICATE-----\n    MIIB\tfake\n    -----END CERTIFICATE-----",
+		},
+		{
+			desc:     "trailing newline does not leave dangling indentation",
+			template: "data:\n  cert: |\n{{ indent 4 .Cert }}",
+			cert:     pem + "\n",
+			expected: "data:\n  cert: |\n    -----BEGIN CERTIFICATE-----\n    MIIB\tfake\n    -----END CERTIFICATE-----\n",
+		},
+		{
+			desc:     "nindent with trailing newline",
+			template: "data:\n  cert: |{{ nindent 4 .Cert }}",
+			cert:     pem + "\n",
+			expected: "data:\n  cert: |\n    -----BEGIN CERTIFICATE-----\n    MIIB\tfake\n    -----END CERTIFICATE-----\n",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			cert := testCase.cert
+			if cert == "" {
+				cert = pem
+			}
+			tpl := template.Must(template.New("indent").Funcs(funcMap).Parse(testCase.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, map[string]string{"Cert": cert}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_ToYAML_FromYAML(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+
+	kubeDNS := &kops.KubeDNSConfig{
+		Provider: "CoreDNS",
+		Domain:   "cluster.local",
+		ServerIP: "100.64.0.10",
+	}
+
+	encoded, err := tf.ToYAML(kubeDNS)
+	if err != nil {
+		t.Fatalf("unexpected error from ToYAML: %v", err)
+	}
+
+	// Repeated renders must be byte-identical
+	again, err := tf.ToYAML(kubeDNS)
+	if err != nil {
+		t.Fatalf("unexpected error from ToYAML: %v", err)
+	}
+	if encoded != again {
+		t.Errorf("ToYAML output is not deterministic: %q vs %q", encoded, again)
+	}
+
+	parsed, err := tf.FromYAML(encoded)
+	if err != nil {
+		t.Fatalf("unexpected error from FromYAML: %v", err)
+	}
+	m, ok := parsed.(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected map from FromYAML, got %T", parsed)
+	}
+	if m["provider"] != "CoreDNS" || m["domain"] != "cluster.local" || m["serverIP"] != "100.64.0.10" {
+		t.Errorf("round-trip differs: %+v", m)
+	}
+
+	if _, err := tf.FromYAML(":\tnot yaml"); err == nil {
+		t.Errorf("expected error parsing invalid yaml")
+	}
+
+	// ToYAML output embeds cleanly as a multi-line block via indent
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+	tpl := template.Must(template.New("embed").Funcs(funcMap).Parse("config: |\n{{ indent 2 (ToYAML .KubeDNS) }}"))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, map[string]interface{}{"KubeDNS": kubeDNS}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buffer.String(), "  domain: cluster.local") {
+		t.Errorf("embedded yaml block differs: %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_ToJSON_FromJSON(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	docker := &kops.DockerConfig{
+		LogDriver: fi.String("json-file"),
+		LogOpt:    []string{"max-size=10m"},
+	}
+
+	tpl := template.Must(template.New("json").Funcs(funcMap).Parse(`{{ ToJSON .Docker }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, map[string]interface{}{"Docker": docker}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := `{"logDriver":"json-file","logOpt":["max-size=10m"]}`
+	if buffer.String() != expected {
+		t.Errorf("rendered json differs: %q instead of %q", buffer.String(), expected)
+	}
+
+	parsed, err := tf.FromJSON(buffer.String())
+	if err != nil {
+		t.Fatalf("unexpected error from FromJSON: %v", err)
+	}
+	m, ok := parsed.(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected map from FromJSON, got %T", parsed)
+	}
+	if m["logDriver"] != "json-file" {
+		t.Errorf("round-trip differs: %+v", m)
+	}
+
+	if _, err := tf.FromJSON("{not json"); err == nil {
+		t.Errorf("expected error parsing invalid json")
+	}
+
+	// Unmarshalable values surface a descriptive error instead of empty output
+	if _, err := tf.ToJSON(func() {}); err == nil {
+		t.Errorf("expected error marshaling unsupported type")
+	}
+}
+
+func Test_TemplateFunctions_Split(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "empty input ranges over nothing",
+			template: `{{ range split .Input "," }}--allow={{ . }} {{ end }}`,
+			input:    "",
+			expected: "",
+		},
+		{
+			desc:     "single element",
+			template: `{{ range split .Input "," }}--allow={{ . }} {{ end }}`,
+			input:    "10.0.0.0/8",
+			expected: "--allow=10.0.0.0/8 ",
+		},
+		{
+			desc:     "join round-trips split",
+			template: `{{ join (split .Input ",") "," }}`,
+			input:    "a,b,c",
+			expected: "a,b,c",
+		},
+		{
+			desc:     "splitN limits the number of elements",
+			template: `{{ join (splitN .Input "=" 2) "|" }}`,
+			input:    "key=value=with=equals",
+			expected: "key|value=with=equals",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("split").Funcs(funcMap).Parse(testCase.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, map[string]string{"Input": testCase.input}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_InstanceGroupsByRole(t *testing.T) {
+	instanceGroups := []*kops.InstanceGroup{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "nodes-b"},
+			Spec:       kops.InstanceGroupSpec{Role: kops.InstanceGroupRoleNode},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "master-us-test-1b"},
+			Spec:       kops.InstanceGroupSpec{Role: kops.InstanceGroupRoleMaster},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "master-us-test-1a"},
+			Spec:       kops.InstanceGroupSpec{Role: kops.InstanceGroupRoleMaster},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "nodes-a"},
+			Spec:       kops.InstanceGroupSpec{Role: kops.InstanceGroupRoleNode},
+		},
+	}
+	tf := &TemplateFunctions{
+		cluster:        &kops.Cluster{},
+		instanceGroups: instanceGroups,
+	}
+
+	masters, err := tf.GetMasterInstanceGroups()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, ig := range masters {
+		names = append(names, ig.ObjectMeta.Name)
+	}
+	expected := []string{"master-us-test-1a", "master-us-test-1b"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("master instance groups differ: %+v instead of %+v", names, expected)
+	}
+
+	nodes, err := tf.GetNodeInstanceGroups()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names = nil
+	for _, ig := range nodes {
+		names = append(names, ig.ObjectMeta.Name)
+	}
+	expected = []string{"nodes-a", "nodes-b"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("node instance groups differ: %+v instead of %+v", names, expected)
+	}
+
+	// No bastions configured: empty slice, not an error
+	bastions, err := tf.GetBastionInstanceGroups()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bastions) != 0 {
+		t.Errorf("expected no bastion instance groups, got %+v", bastions)
+	}
+
+	tf.instanceGroups = append(tf.instanceGroups,
+		&kops.InstanceGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "bastion-2"},
+			Spec:       kops.InstanceGroupSpec{Role: kops.InstanceGroupRoleBastion},
+		},
+		&kops.InstanceGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "bastion-1"},
+			Spec:       kops.InstanceGroupSpec{Role: kops.InstanceGroupRoleBastion},
+		},
+	)
+	bastions, err = tf.GetBastionInstanceGroups()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names = nil
+	for _, ig := range bastions {
+		names = append(names, ig.ObjectMeta.Name)
+	}
+	expected = []string{"bastion-1", "bastion-2"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("bastion instance groups differ: %+v instead of %+v", names, expected)
+	}
+}
+
+func Test_TemplateFunctions_Trim(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "trailing dot is stripped from a DNS zone",
+			template: `--zone={{ trimSuffix "." .Input }}`,
+			input:    "example.com.",
+			expected: "--zone=example.com",
+		},
+		{
+			desc:     "zone without a trailing dot is unchanged",
+			template: `--zone={{ trimSuffix "." .Input }}`,
+			input:    "example.com",
+			expected: "--zone=example.com",
+		},
+		{
+			desc:     "trimPrefix strips only at the start",
+			template: `{{ trimPrefix "k8s.io/" .Input }}`,
+			input:    "k8s.io/pause:k8s.io/3.1",
+			expected: "pause:k8s.io/3.1",
+		},
+		{
+			desc:     "trim strips surrounding whitespace",
+			template: `{{ trim .Input }}`,
+			input:    "  value \n",
+			expected: "value",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("trim").Funcs(funcMap).Parse(testCase.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, map[string]string{"Input": testCase.input}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Case(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "lower with EncodeGCELabel gives a valid GCE label",
+			template: `{{ EncodeGCELabel (lower .Input) }}`,
+			input:    "Nodes-HighMem",
+			expected: "nodes-2dhighmem",
+		},
+		{
+			desc:     "upper",
+			template: `{{ upper .Input }}`,
+			input:    "aws",
+			expected: "AWS",
+		},
+		{
+			desc:     "title",
+			template: `{{ title .Input }}`,
+			input:    "public",
+			expected: "Public",
+		},
+		{
+			desc:     "empty string is safe",
+			template: `{{ lower .Input }}{{ upper .Input }}{{ title .Input }}`,
+			input:    "",
+			expected: "",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("case").Funcs(funcMap).Parse(testCase.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, map[string]string{"Input": testCase.input}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_StringPredicates(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "contains in an if pipeline",
+			template: `{{ if contains "gcr.io" .Input }}registry{{ end }}`,
+			input:    "k8s.gcr.io/pause:3.1",
+			expected: "registry",
+		},
+		{
+			desc:     "hasPrefix in an if pipeline",
+			template: `{{ if hasPrefix "1.8" .Input }}modern{{ else }}legacy{{ end }}`,
+			input:    "1.8.0",
+			expected: "modern",
+		},
+		{
+			desc:     "hasSuffix in an if pipeline",
+			template: `{{ if hasSuffix ".k8s.local" .Input }}gossip{{ end }}`,
+			input:    "cluster.example.com",
+			expected: "",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("predicates").Funcs(funcMap).Parse(testCase.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, map[string]string{"Input": testCase.input}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_SpecAccessors(t *testing.T) {
+	t.Run("KubeAPIServer is nil-safe", func(t *testing.T) {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{},
+		}
+		if tf.KubeAPIServer() == nil {
+			t.Fatalf("expected non-nil KubeAPIServer for empty spec")
+		}
+	})
+	t.Run("KubeControllerManager is nil-safe", func(t *testing.T) {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{},
+		}
+		if tf.KubeControllerManager() == nil {
+			t.Fatalf("expected non-nil KubeControllerManager for empty spec")
+		}
+	})
+	t.Run("KubeControllerManager returns the spec field when set", func(t *testing.T) {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				KubeControllerManager: &kops.KubeControllerManagerConfig{
+					ClusterCIDR: "100.96.0.0/11",
+				},
+			}},
+		}
+		if tf.KubeControllerManager().ClusterCIDR != "100.96.0.0/11" {
+			t.Errorf("KubeControllerManager differs: %+v", tf.KubeControllerManager())
+		}
+	})
+	t.Run("KubeScheduler is nil-safe", func(t *testing.T) {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{},
+		}
+		if tf.KubeScheduler() == nil {
+			t.Fatalf("expected non-nil KubeScheduler for empty spec")
+		}
+	})
+	t.Run("KubeControllerManager fields are readable in templates", func(t *testing.T) {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				KubeControllerManager: &kops.KubeControllerManagerConfig{
+					ClusterCIDR:       "100.96.0.0/11",
+					AllocateNodeCIDRs: fi.Bool(true),
+				},
+			}},
+		}
+		funcMap := make(template.FuncMap)
+		if err := tf.AddTo(funcMap, nil); err != nil {
+			t.Fatalf("error from AddTo: %v", err)
+		}
+		tpl := template.Must(template.New("kcm").Funcs(funcMap).Parse(
+			`{{ KubeControllerManager.ClusterCIDR }} {{ WithDefaultBool KubeControllerManager.AllocateNodeCIDRs false }}`))
+		var buffer bytes.Buffer
+		if err := tpl.Execute(&buffer, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if buffer.String() != "100.96.0.0/11 true" {
+			t.Errorf("unexpected output: %q", buffer.String())
+		}
+	})
+	t.Run("KubeAPIServer returns the spec field when set", func(t *testing.T) {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				KubeAPIServer: &kops.KubeAPIServerConfig{
+					EnableAdmissionPlugins: []string{"NodeRestriction"},
+				},
+			}},
+		}
+		if !reflect.DeepEqual(tf.KubeAPIServer().EnableAdmissionPlugins, []string{"NodeRestriction"}) {
+			t.Errorf("KubeAPIServer differs: %+v", tf.KubeAPIServer())
+		}
+	})
+}
+
+func Test_TemplateFunctions_WithDefault(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		data     map[string]interface{}
+		expected string
+	}{
+		{
+			desc:     "nil string pointer uses the default",
+			template: `--log-level={{ WithDefaultString .V "info" }}`,
+			data:     map[string]interface{}{"V": (*string)(nil)},
+			expected: "--log-level=info",
+		},
+		{
+			desc:     "empty string value wins over the default",
+			template: `--log-level={{ WithDefaultString .V "info" }}`,
+			data:     map[string]interface{}{"V": fi.String("")},
+			expected: "--log-level=",
+		},
+		{
+			desc:     "set string value wins over the default",
+			template: `--log-level={{ WithDefaultString .V "info" }}`,
+			data:     map[string]interface{}{"V": fi.String("debug")},
+			expected: "--log-level=debug",
+		},
+		{
+			desc:     "nil int32 pointer uses the default",
+			template: `{{ WithDefaultInt32 .V 3 }}`,
+			data:     map[string]interface{}{"V": (*int32)(nil)},
+			expected: "3",
+		},
+		{
+			desc:     "zero int32 value wins over the default",
+			template: `{{ WithDefaultInt32 .V 3 }}`,
+			data:     map[string]interface{}{"V": fi.Int32(0)},
+			expected: "0",
+		},
+		{
+			desc:     "set int32 value wins over the default",
+			template: `{{ WithDefaultInt32 .V 3 }}`,
+			data:     map[string]interface{}{"V": fi.Int32(7)},
+			expected: "7",
+		},
+		{
+			desc:     "nil int64 pointer uses the default",
+			template: `{{ WithDefaultInt64 .V 443 }}`,
+			data:     map[string]interface{}{"V": (*int64)(nil)},
+			expected: "443",
+		},
+		{
+			desc:     "zero int64 value wins over the default",
+			template: `{{ WithDefaultInt64 .V 443 }}`,
+			data:     map[string]interface{}{"V": fi.Int64(0)},
+			expected: "0",
+		},
+		{
+			desc:     "set int64 value wins over the default",
+			template: `{{ WithDefaultInt64 .V 443 }}`,
+			data:     map[string]interface{}{"V": fi.Int64(8443)},
+			expected: "8443",
+		},
+		{
+			desc:     "nil bool pointer uses the default",
+			template: `{{ WithDefaultBool .V true }}`,
+			data:     map[string]interface{}{"V": (*bool)(nil)},
+			expected: "true",
+		},
+		{
+			desc:     "false bool value wins over the default",
+			template: `{{ WithDefaultBool .V true }}`,
+			data:     map[string]interface{}{"V": fi.Bool(false)},
+			expected: "false",
+		},
+		{
+			desc:     "nil int pointer uses the default",
+			template: `{{ WithDefaultInt .V 3 }}`,
+			data:     map[string]interface{}{"V": (*int32)(nil)},
+			expected: "3",
+		},
+		{
+			desc:     "zero int value wins over the default",
+			template: `{{ WithDefaultInt .V 3 }}`,
+			data:     map[string]interface{}{"V": fi.Int32(0)},
+			expected: "0",
+		},
+		{
+			desc:     "set int value wins over the default",
+			template: `{{ WithDefaultInt .V 3 }}`,
+			data:     map[string]interface{}{"V": fi.Int32(5)},
+			expected: "5",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("default").Funcs(funcMap).Parse(testCase.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, testCase.data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Ternary(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		tags     []string
+		expected string
+	}{
+		{
+			desc:     "true branch with HasTag",
+			template: `{{ ternary "internal" "external" (HasTag "_private") }}`,
+			tags:     []string{"_private"},
+			expected: "internal",
+		},
+		{
+			desc:     "false branch with HasTag",
+			template: `{{ ternary "internal" "external" (HasTag "_private") }}`,
+			expected: "external",
+		},
+		{
+			desc:     "int values",
+			template: `{{ ternary 1 3 (HasTag "_single") }}`,
+			expected: "3",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+				tags:    sets.NewString(testCase.tags...),
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("ternary").Funcs(funcMap).Parse(testCase.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Quote(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tagValue := `say "hello" \o/`
+	tpl := template.Must(template.New("quote").Funcs(funcMap).Parse(`value: {{ quote .Tag }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, map[string]string{"Tag": tagValue}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var parsed map[string]string
+	if err := yaml.Unmarshal(buffer.Bytes(), &parsed); err != nil {
+		t.Fatalf("rendered output %q is not valid yaml: %v", buffer.String(), err)
+	}
+	if parsed["value"] != tagValue {
+		t.Errorf("value did not round-trip through yaml: %q instead of %q", parsed["value"], tagValue)
+	}
+
+	tpl = template.Must(template.New("squote").Funcs(funcMap).Parse(`{{ squote .V }}`))
+	buffer.Reset()
+	if err := tpl.Execute(&buffer, map[string]interface{}{"V": 42}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "'42'" {
+		t.Errorf("squote differs: %q instead of %q", buffer.String(), "'42'")
+	}
+}
+
+func Test_TemplateFunctions_Hash(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "sha256 of a fixed input",
+			template: `{{ sha256 .Input }}`,
+			input:    "hello world",
+			expected: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+		},
+		{
+			desc:     "sha1 of a fixed input",
+			template: `{{ sha1 .Input }}`,
+			input:    "hello world",
+			expected: "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed",
+		},
+		{
+			desc:     "sha256 of the empty string",
+			template: `{{ sha256 .Input }}`,
+			input:    "",
+			expected: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("hash").Funcs(funcMap).Parse(testCase.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, map[string]string{"Input": testCase.input}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("digest differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Required(t *testing.T) {
+	tests := []struct {
+		desc        string
+		data        map[string]interface{}
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "present value passes through",
+			data:     map[string]interface{}{"V": "100.64.0.0/13"},
+			expected: "100.64.0.0/13",
+		},
+		{
+			desc:        "empty string fails the render",
+			data:        map[string]interface{}{"V": ""},
+			expectError: true,
+		},
+		{
+			desc:        "nil pointer fails the render",
+			data:        map[string]interface{}{"V": (*string)(nil)},
+			expectError: true,
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("required").Funcs(funcMap).Parse(`{{ required "serviceClusterIPRange is required" .V }}`))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, testCase.data)
+			if testCase.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				if !strings.Contains(err.Error(), "serviceClusterIPRange is required") {
+					t.Errorf("error does not include the caller's message: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+
+	// A message containing % must pass through verbatim, not be treated
+	// as a format string
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+	tpl := template.Must(template.New("requiredpercent").Funcs(funcMap).Parse(`{{ required "need 100% of masters" .V }}`))
+	var buffer bytes.Buffer
+	err := tpl.Execute(&buffer, map[string]interface{}{"V": ""})
+	if err == nil {
+		t.Fatalf("expected error, got output %q", buffer.String())
+	}
+	if !strings.Contains(err.Error(), "need 100% of masters") {
+		t.Errorf("error does not include the caller's message verbatim: %v", err)
+	}
+}
+
+func Test_TemplateFunctions_DictList(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	// A dict built inline and passed to a nested template
+	tpl := template.Must(template.New("dict").Funcs(funcMap).Parse(
+		`{{ define "toleration" }}key={{ .key }},effect={{ .effect }}{{ end }}` +
+			`{{ template "toleration" (dict "key" "node-role" "effect" "NoSchedule") }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "key=node-role,effect=NoSchedule"
+	if buffer.String() != expected {
+		t.Errorf("rendered output differs: %q instead of %q", buffer.String(), expected)
+	}
+
+	tpl = template.Must(template.New("odd").Funcs(funcMap).Parse(`{{ dict "key" }}`))
+	buffer.Reset()
+	if err := tpl.Execute(&buffer, nil); err == nil {
+		t.Errorf("expected error for odd number of dict arguments")
+	}
+
+	tpl = template.Must(template.New("list").Funcs(funcMap).Parse(`{{ range list "a" "b" }}{{ . }}{{ end }}`))
+	buffer.Reset()
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "ab" {
+		t.Errorf("list differs: %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_Merge(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	clusterLabels := map[string]string{"env": "prod", "team": "infra"}
+	igLabels := map[string]string{"env": "nodes"}
+
+	tpl := template.Must(template.New("merge").Funcs(funcMap).Parse(`{{ index (merge .Cluster .IG) "env" }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, map[string]interface{}{"Cluster": clusterLabels, "IG": igLabels}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "nodes" {
+		t.Errorf("expected the instance group value to win, got %q", buffer.String())
+	}
+
+	// Inputs must not be mutated: the same spec maps are shared across renders
+	if clusterLabels["env"] != "prod" || len(clusterLabels) != 2 {
+		t.Errorf("cluster labels were mutated: %+v", clusterLabels)
+	}
+	if igLabels["team"] != "" || len(igLabels) != 1 {
+		t.Errorf("instance group labels were mutated: %+v", igLabels)
+	}
+}
+
+func Test_TemplateFunctions_IsGossipCluster(t *testing.T) {
+	tests := []struct {
+		masterInternalName string
+		expected           bool
+	}{
+		{"api.internal.mycluster.k8s.local", true},
+		{"api.internal.mycluster.example.com", false},
+	}
+	for _, testCase := range tests {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+				MasterInternalName: testCase.masterInternalName,
+			}},
+		}
+		if actual := tf.IsGossipCluster(); actual != testCase.expected {
+			t.Errorf("IsGossipCluster(%q) = %v, expected %v", testCase.masterInternalName, actual, testCase.expected)
+		}
+	}
+}
+
+func Test_TemplateFunctions_Seq(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		expected string
+	}{
+		{
+			desc:     "initial-cluster style rendering",
+			template: `--initial-cluster={{ range seq 1 3 }}etcd-{{ . }},{{ end }}`,
+			expected: "--initial-cluster=etcd-1,etcd-2,etcd-3,",
+		},
+		{
+			desc:     "until counts from zero",
+			template: `{{ range until 3 }}{{ . }}{{ end }}`,
+			expected: "012",
+		},
+		{
+			desc:     "zero count is empty",
+			template: `{{ range until 0 }}{{ . }}{{ end }}`,
+			expected: "",
+		},
+		{
+			desc:     "negative count is empty",
+			template: `{{ range until -2 }}{{ . }}{{ end }}`,
+			expected: "",
+		},
+		{
+			desc:     "inverted seq is empty",
+			template: `{{ range seq 3 1 }}{{ . }}{{ end }}`,
+			expected: "",
+		},
+	}
+	for _, testCase := range tests {
+		t.Run(testCase.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("seq").Funcs(funcMap).Parse(testCase.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != testCase.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), testCase.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_NetworkCIDRs(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{Spec: kops.ClusterSpec{
+			NetworkCIDR:       "172.20.0.0/16",
+			NonMasqueradeCIDR: "100.64.0.0/10",
+		}},
+	}
+
+	if v, err := tf.NetworkCIDR(); err != nil || v != "172.20.0.0/16" {
+		t.Errorf("NetworkCIDR = (%q, %v)", v, err)
+	}
+	if v, err := tf.NonMasqueradeCIDR(); err != nil || v != "100.64.0.0/10" {
+		t.Errorf("NonMasqueradeCIDR = (%q, %v)", v, err)
+	}
+
+	empty := &TemplateFunctions{cluster: &kops.Cluster{}}
+	if _, err := empty.NetworkCIDR(); err == nil {
+		t.Errorf("expected error for empty networkCIDR")
+	}
+	if _, err := empty.NonMasqueradeCIDR(); err == nil {
+		t.Errorf("expected error for empty nonMasqueradeCIDR")
+	}
+}
+
+func Test_TemplateFunctions_UpperLower(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tpl := template.Must(template.New("case").Funcs(funcMap).Parse(`{{ Upper "aws" }} {{ Lower "KubeProxy" }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "AWS kubeproxy" {
+		t.Errorf("unexpected output: %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_TrimPrefixSuffix(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		expected string
+	}{
+		{
+			desc:     "TrimPrefix strips scheme",
+			template: `{{ TrimPrefix "https://registry.example.com" "https://" }}`,
+			expected: "registry.example.com",
+		},
+		{
+			desc:     "TrimPrefix leaves non-matching input alone",
+			template: `{{ TrimPrefix "registry.example.com" "https://" }}`,
+			expected: "registry.example.com",
+		},
+		{
+			desc:     "TrimSuffix strips one trailing slash only",
+			template: `{{ TrimSuffix "https://example.com//" "/" }}`,
+			expected: "https://example.com/",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_SplitComma(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "simple list",
+			template: `{{ range SplitComma .Input }}[{{ . }}]{{ end }}`,
+			input:    "a,b,c",
+			expected: "[a][b][c]",
+		},
+		{
+			desc:     "single element",
+			template: `{{ range SplitComma .Input }}[{{ . }}]{{ end }}`,
+			input:    "alone",
+			expected: "[alone]",
+		},
+		{
+			desc:     "empty string yields one empty element",
+			template: `{{ len (SplitComma .Input) }}`,
+			input:    "",
+			expected: "1",
+		},
+		{
+			desc:     "whitespace is preserved",
+			template: `{{ range SplitComma .Input }}[{{ . }}]{{ end }}`,
+			input:    "a, b",
+			expected: "[a][ b]",
+		},
+		{
+			desc:     "trimmed variant strips whitespace",
+			template: `{{ range SplitCommaTrimmed .Input }}[{{ . }}]{{ end }}`,
+			input:    " a , b ,c",
+			expected: "[a][b][c]",
+		},
+		{
+			desc:     "SplitOn with custom separator",
+			template: `{{ range SplitOn .Input ":" }}[{{ . }}]{{ end }}`,
+			input:    "a:b",
+			expected: "[a][b]",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, map[string]string{"Input": test.input}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_AddressFamily(t *testing.T) {
+	tests := []struct {
+		desc        string
+		template    string
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "IsIPv4 on v4 address",
+			template: `{{ IsIPv4 "100.64.0.10" }}`,
+			expected: "true",
+		},
+		{
+			desc:     "IsIPv4 on v6 address",
+			template: `{{ IsIPv4 "fd00::1" }}`,
+			expected: "false",
+		},
+		{
+			desc:     "IsIPv6 on v6 address",
+			template: `{{ IsIPv6 "fd00::1" }}`,
+			expected: "true",
+		},
+		{
+			desc:     "IsIPv6 on v4 address",
+			template: `{{ IsIPv6 "100.64.0.10" }}`,
+			expected: "false",
+		},
+		{
+			desc:     "IsIPv6 on garbage",
+			template: `{{ IsIPv6 "not-an-ip" }}`,
+			expected: "false",
+		},
+		{
+			desc:     "ParseCIDRIP v4",
+			template: `{{ ParseCIDRIP "100.64.0.0/13" }}`,
+			expected: "100.64.0.0",
+		},
+		{
+			desc:     "ParseCIDRIP v6",
+			template: `{{ ParseCIDRIP "fd00::/64" }}`,
+			expected: "fd00::",
+		},
+		{
+			desc:        "ParseCIDRIP on bare address",
+			template:    `{{ ParseCIDRIP "100.64.0.10" }}`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, nil)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_CIDRMath(t *testing.T) {
+	tests := []struct {
+		desc        string
+		template    string
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "cidrSubnet first",
+			template: `{{ cidrSubnet "10.0.0.0/16" 4 0 }}`,
+			expected: "10.0.0.0/20",
+		},
+		{
+			desc:     "cidrSubnet third",
+			template: `{{ cidrSubnet "10.0.0.0/16" 4 2 }}`,
+			expected: "10.0.32.0/20",
+		},
+		{
+			desc:        "cidrSubnet index out of range",
+			template:    `{{ cidrSubnet "10.0.0.0/16" 4 16 }}`,
+			expectError: true,
+		},
+		{
+			desc:        "cidrSubnet malformed",
+			template:    `{{ cidrSubnet "10.0.0.0" 4 0 }}`,
+			expectError: true,
+		},
+		{
+			desc:     "cidrHost dns ip",
+			template: `{{ cidrHost "100.64.0.0/13" 10 }}`,
+			expected: "100.64.0.10",
+		},
+		{
+			desc:     "cidrHost last host in a /28",
+			template: `{{ cidrHost "192.168.0.16/28" 15 }}`,
+			expected: "192.168.0.31",
+		},
+		{
+			desc:        "cidrHost out of range for /28",
+			template:    `{{ cidrHost "192.168.0.16/28" 16 }}`,
+			expectError: true,
+		},
+		{
+			desc:     "cidrContains inside",
+			template: `{{ cidrContains "10.0.0.0/16" "10.0.200.1" }}`,
+			expected: "true",
+		},
+		{
+			desc:     "cidrContains outside",
+			template: `{{ cidrContains "10.0.0.0/16" "10.1.0.1" }}`,
+			expected: "false",
+		},
+		{
+			desc:        "cidrContains bad ip",
+			template:    `{{ cidrContains "10.0.0.0/16" "not-an-ip" }}`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, nil)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Quantities(t *testing.T) {
+	tests := []struct {
+		desc        string
+		template    string
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "MiB",
+			template: `{{ MiB 200 }}`,
+			expected: "200Mi",
+		},
+		{
+			desc:     "GiB",
+			template: `{{ GiB 2 }}`,
+			expected: "2Gi",
+		},
+		{
+			desc:     "parseQuantity of MiB output",
+			template: `{{ parseQuantity (MiB 1) }}`,
+			expected: "1048576",
+		},
+		{
+			desc:     "parseQuantity of fractional GiB",
+			template: `{{ parseQuantity "1.5Gi" }}`,
+			expected: "1610612736",
+		},
+		{
+			desc:        "malformed quantity",
+			template:    `{{ parseQuantity "12wombats" }}`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, nil)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Env(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+		templateEnv: map[string]string{
+			"KOPS_TEMPLATE_PROXY_HOST": "proxy.example.com",
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tests := []struct {
+		desc        string
+		template    string
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "allowed variable",
+			template: `{{ Env "KOPS_TEMPLATE_PROXY_HOST" }}`,
+			expected: "proxy.example.com",
+		},
+		{
+			desc:        "disallowed variable",
+			template:    `{{ Env "HOME" }}`,
+			expectError: true,
+		},
+		{
+			desc:        "unset variable",
+			template:    `{{ Env "KOPS_TEMPLATE_MISSING" }}`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, nil)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("unexpected output: %q", buffer.String())
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_Region(t *testing.T) {
+	tests := []struct {
+		desc        string
+		region      string
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "region is set",
+			region:   "us-east-1",
+			expected: "us-east-1",
+		},
+		{
+			desc:        "region is empty",
+			region:      "",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+				region:  test.region,
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New("region").Funcs(funcMap).Parse(`{{ Region }}`))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, nil)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("unexpected region: %q", buffer.String())
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_CloudProvider(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				CloudProvider: "aws",
+			},
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tpl := template.Must(template.New("cloudprovider").Funcs(funcMap).Parse(
+		`{{ CloudProvider }} {{ IsCloudProvider "aws" }} {{ IsCloudProvider "gce" }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "aws true false" {
+		t.Errorf("unexpected output: %q", buffer.String())
+	}
+
+	provider, err := tf.CloudProvider()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if provider != kops.CloudProviderAWS {
+		t.Errorf("unexpected provider: %q", provider)
+	}
+	if !tf.IsAWS() || tf.IsGCE() || tf.IsVSphere() {
+		t.Errorf("unexpected provider predicates: IsAWS=%v IsGCE=%v IsVSphere=%v", tf.IsAWS(), tf.IsGCE(), tf.IsVSphere())
+	}
+
+	// A misspelled provider must error rather than silently render
+	// empty blocks
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				CloudProvider: "gse",
+			},
+		},
+	}
+	if _, err := tf.CloudProvider(); err == nil {
+		t.Errorf("expected error for misspelled cloudprovider")
+	}
+	if _, err := tf.DnsControllerArgv(); err == nil {
+		t.Errorf("expected error from DnsControllerArgv for misspelled cloudprovider")
+	}
+	if _, err := tf.ExternalDnsArgv(); err == nil {
+		t.Errorf("expected error from ExternalDnsArgv for misspelled cloudprovider")
+	}
+}
+
+func Test_TemplateFunctions_Regex(t *testing.T) {
+	tests := []struct {
+		desc        string
+		template    string
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "match",
+			template: `{{ regexMatch "^us-[a-z]+-[0-9][a-z]$" "us-east-1a" }}`,
+			expected: "true",
+		},
+		{
+			desc:     "no match",
+			template: `{{ regexMatch "^us-" "eu-west-1a" }}`,
+			expected: "false",
+		},
+		{
+			desc:     "replace with capture group",
+			template: `{{ regexReplaceAll "^v([0-9.]+)-.*$" "v1.16.3-beta.1" "$1" }}`,
+			expected: "1.16.3",
+		},
+		{
+			desc:        "invalid pattern",
+			template:    `{{ regexMatch "[unclosed" "value" }}`,
+			expectError: true,
+		},
+		{
+			desc:        "invalid pattern in replace",
+			template:    `{{ regexReplaceAll "[unclosed" "value" "x" }}`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, nil)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_IsKubernetesGTE(t *testing.T) {
+	tests := []struct {
+		clusterVersion string
+		version        string
+		expected       bool
+	}{
+		{clusterVersion: "1.8.0", version: "1.8.0", expected: true},
+		{clusterVersion: "1.8.0", version: "1.8.1", expected: false},
+		{clusterVersion: "1.8.1", version: "1.8.0", expected: true},
+		{clusterVersion: "v1.8.0", version: "1.8.0", expected: true},
+		{clusterVersion: "1.8.0-beta.1", version: "1.8.0", expected: true},
+		{clusterVersion: "1.7.9", version: "1.8.0", expected: false},
+	}
+	for _, test := range tests {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{
+				Spec: kops.ClusterSpec{
+					KubernetesVersion: test.clusterVersion,
+				},
+			},
+		}
+		funcMap := make(template.FuncMap)
+		if err := tf.AddTo(funcMap, nil); err != nil {
+			t.Fatalf("error from AddTo: %v", err)
+		}
+
+		tpl := template.Must(template.New("gte").Funcs(funcMap).Parse(`{{ IsKubernetesGTE .Version }}`))
+		var buffer bytes.Buffer
+		if err := tpl.Execute(&buffer, map[string]string{"Version": test.version}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if buffer.String() != fmt.Sprintf("%v", test.expected) {
+			t.Errorf("IsKubernetesGTE(%q) with cluster version %q: expected %v, got %s", test.version, test.clusterVersion, test.expected, buffer.String())
+		}
+	}
+
+	// An unparseable cluster version must fail the render, not panic
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				KubernetesVersion: "not-a-version",
+			},
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+	tpl := template.Must(template.New("gte").Funcs(funcMap).Parse(`{{ IsKubernetesGTE "1.8.0" }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err == nil {
+		t.Errorf("expected error rendering IsKubernetesGTE with unparseable cluster version, got %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_SemverCompare(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{constraint: ">= 1.8.0", version: "1.8.0", expected: true},
+		{constraint: ">= 1.8.0", version: "1.7.9", expected: false},
+		{constraint: "~1.8.x", version: "1.8.5", expected: true},
+		{constraint: "< 1.9.0", version: "1.8.0", expected: true},
+	}
+	for _, test := range tests {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{},
+		}
+		funcMap := make(template.FuncMap)
+		if err := tf.AddTo(funcMap, nil); err != nil {
+			t.Fatalf("error from AddTo: %v", err)
+		}
+
+		tpl := template.Must(template.New("semver").Funcs(funcMap).Parse(`{{ semverCompare .Constraint .Version }}`))
+		var buffer bytes.Buffer
+		if err := tpl.Execute(&buffer, map[string]string{"Constraint": test.constraint, "Version": test.version}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if buffer.String() != fmt.Sprintf("%v", test.expected) {
+			t.Errorf("semverCompare(%q, %q): expected %v, got %s", test.constraint, test.version, test.expected, buffer.String())
+		}
+	}
+}
+
+func Test_TemplateFunctions_DNSZone(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				DNSZone: "example.com",
+			},
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tpl := template.Must(template.New("dnszone").Funcs(funcMap).Parse(`{{ DNSZone }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "example.com" {
+		t.Errorf("unexpected DNSZone: %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_UrlParse(t *testing.T) {
+	tests := []struct {
+		desc        string
+		template    string
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "url with port",
+			template: `{{ $u := urlParse "https://registry.example.com:5000/v2/" }}{{ $u.scheme }} {{ $u.host }} {{ $u.port }} {{ $u.path }}`,
+			expected: "https registry.example.com 5000 /v2/",
+		},
+		{
+			desc:     "url without port",
+			template: `{{ $u := urlParse "https://discovery.example.com/cluster" }}{{ $u.host }}:{{ $u.port }}{{ $u.path }}`,
+			expected: "discovery.example.com:/cluster",
+		},
+		{
+			desc:     "trailing slash",
+			template: `{{ $u := urlParse "s3://state-store.example.com/" }}{{ $u.scheme }}{{ $u.path }}`,
+			expected: "s3/",
+		},
+		{
+			desc:        "missing scheme",
+			template:    `{{ urlParse "registry.example.com/v2" }}`,
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, nil)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_KubernetesVersion(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				KubernetesVersion: "1.16.3",
+			},
+		},
+	}
+
+	tests := []struct {
+		version  string
+		expected bool
+	}{
+		{version: "1.15.0", expected: true},
+		{version: "1.16.0", expected: true},
+		{version: "1.16.3", expected: true},
+		{version: "1.16.4", expected: false},
+		{version: "1.17.0", expected: false},
+	}
+	for _, test := range tests {
+		actual, err := tf.KubernetesVersionAtLeast(test.version)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", test.version, err)
+		}
+		if actual != test.expected {
+			t.Errorf("KubernetesVersionAtLeast(%q): expected %v, got %v", test.version, test.expected, actual)
+		}
+	}
+
+	if _, err := tf.KubernetesVersionAtLeast("not-a-version"); err == nil {
+		t.Errorf("expected error for invalid version")
+	}
+
+	versionTests := []struct {
+		desc           string
+		clusterVersion string
+		template       string
+		expected       string
+		expectError    bool
+	}{
+		{
+			desc:           "plain version",
+			clusterVersion: "1.16.3",
+			template:       `{{ KubernetesVersion }}`,
+			expected:       "1.16.3",
+		},
+		{
+			desc:           "v-prefixed version is normalized",
+			clusterVersion: "v1.16.3",
+			template:       `{{ KubernetesVersion }}`,
+			expected:       "1.16.3",
+		},
+		{
+			desc:           "empty version is an error",
+			clusterVersion: "",
+			template:       `{{ KubernetesVersion }}`,
+			expectError:    true,
+		},
+		{
+			desc:           "major minor",
+			clusterVersion: "v1.16.3",
+			template:       `{{ KubernetesMajorMinor }}`,
+			expected:       "1.16",
+		},
+		{
+			desc:           "major minor with empty version is an error",
+			clusterVersion: "",
+			template:       `{{ KubernetesMajorMinor }}`,
+			expectError:    true,
+		},
+	}
+	for _, test := range versionTests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{
+					Spec: kops.ClusterSpec{
+						KubernetesVersion: test.clusterVersion,
+					},
+				},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, nil)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("unexpected output: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_EtcdQuorum(t *testing.T) {
+	memberNames := func(n int) []*kops.EtcdMemberSpec {
+		var members []*kops.EtcdMemberSpec
+		for i := 0; i < n; i++ {
+			members = append(members, &kops.EtcdMemberSpec{Name: fmt.Sprintf("etcd-%d", i)})
+		}
+		return members
+	}
+
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				EtcdClusters: []*kops.EtcdClusterSpec{
+					{Name: "single", Members: memberNames(1)},
+					{Name: "main", Members: memberNames(3)},
+					{Name: "large", Members: memberNames(5)},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		clusterName    string
+		expectedCount  int
+		expectedQuorum int
+	}{
+		{clusterName: "single", expectedCount: 1, expectedQuorum: 1},
+		{clusterName: "main", expectedCount: 3, expectedQuorum: 2},
+		{clusterName: "large", expectedCount: 5, expectedQuorum: 3},
+	}
+	for _, test := range tests {
+		count, err := tf.EtcdMemberCount(test.clusterName)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != test.expectedCount {
+			t.Errorf("EtcdMemberCount(%q): expected %d, got %d", test.clusterName, test.expectedCount, count)
+		}
+
+		quorum, err := tf.EtcdQuorumSize(test.clusterName)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if quorum != test.expectedQuorum {
+			t.Errorf("EtcdQuorumSize(%q): expected %d, got %d", test.clusterName, test.expectedQuorum, quorum)
+		}
+	}
+
+	if _, err := tf.EtcdMemberCount("unknown"); err == nil {
+		t.Errorf("expected error for unknown etcd cluster")
+	}
+	if _, err := tf.EtcdQuorumSize("unknown"); err == nil {
+		t.Errorf("expected error for unknown etcd cluster")
+	}
+}
+
+func Test_TemplateFunctions_KubeletConfigForInstanceGroup(t *testing.T) {
+	masterIG := &kops.InstanceGroup{}
+	masterIG.ObjectMeta.Name = "master-1a"
+	masterIG.Spec.Role = kops.InstanceGroupRoleMaster
+
+	nodeIG := &kops.InstanceGroup{}
+	nodeIG.ObjectMeta.Name = "nodes"
+	nodeIG.Spec.Role = kops.InstanceGroupRoleNode
+	nodeIG.Spec.Kubelet = &kops.KubeletConfigSpec{
+		MaxPods: fi.Int32(50),
+		NodeLabels: map[string]string{
+			"tier":      "web",
+			"node-role": "worker",
+		},
+	}
+
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Kubelet: &kops.KubeletConfigSpec{
+					MaxPods:                 fi.Int32(110),
+					EnableDebuggingHandlers: fi.Bool(true),
+					NodeLabels: map[string]string{
+						"tier":    "default",
+						"cluster": "mycluster",
+					},
+				},
+				MasterKubelet: &kops.KubeletConfigSpec{
+					RegisterSchedulable: fi.Bool(false),
+				},
+			},
+		},
+		instanceGroups: []*kops.InstanceGroup{masterIG, nodeIG},
+	}
+
+	merged, err := tf.KubeletConfigForInstanceGroup("nodes")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Int32Value(merged.MaxPods) != 50 {
+		t.Errorf("expected IG MaxPods to win, got %v", fi.Int32Value(merged.MaxPods))
+	}
+	if !fi.BoolValue(merged.EnableDebuggingHandlers) {
+		t.Errorf("expected cluster-level pointer field to carry through")
+	}
+	expectedLabels := map[string]string{
+		"tier":      "web",
+		"node-role": "worker",
+		"cluster":   "mycluster",
+	}
+	if !reflect.DeepEqual(merged.NodeLabels, expectedLabels) {
+		t.Errorf("unexpected NodeLabels: %+v", merged.NodeLabels)
+	}
+
+	masterMerged, err := tf.KubeletConfigForInstanceGroup("master-1a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if masterMerged.RegisterSchedulable == nil || *masterMerged.RegisterSchedulable {
+		t.Errorf("expected master config to merge against MasterKubelet")
+	}
+	if masterMerged.MaxPods != nil {
+		t.Errorf("expected master config not to inherit node kubelet fields")
+	}
+
+	if _, err := tf.KubeletConfigForInstanceGroup("missing"); err == nil {
+		t.Errorf("expected error for unknown instance group")
+	}
+}
+
+func Test_TemplateFunctions_Networking(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Networking: &kops.NetworkingSpec{
+					Calico: &kops.CalicoNetworkingSpec{},
+				},
+			},
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tpl := template.Must(template.New("networking").Funcs(funcMap).Parse(
+		`{{ if Networking.Calico }}calico{{ else }}other{{ end }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "calico" {
+		t.Errorf("unexpected output: %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_KubeProxyEnabled(t *testing.T) {
+	tests := []struct {
+		desc     string
+		spec     kops.ClusterSpec
+		expected bool
+	}{
+		{
+			desc:     "default is enabled",
+			spec:     kops.ClusterSpec{},
+			expected: true,
+		},
+		{
+			desc: "explicit disable",
+			spec: kops.ClusterSpec{
+				KubeProxy: &kops.KubeProxyConfig{Enabled: fi.Bool(false)},
+			},
+			expected: false,
+		},
+		{
+			desc: "kube-router replaces kube-proxy",
+			spec: kops.ClusterSpec{
+				Networking: &kops.NetworkingSpec{
+					Kuberouter: &kops.KuberouterNetworkingSpec{},
+				},
+			},
+			expected: false,
+		},
+		{
+			desc: "explicit enable wins over provider",
+			spec: kops.ClusterSpec{
+				KubeProxy: &kops.KubeProxyConfig{Enabled: fi.Bool(true)},
+				Networking: &kops.NetworkingSpec{
+					Kuberouter: &kops.KuberouterNetworkingSpec{},
+				},
+			},
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{Spec: test.spec},
+			}
+			if tf.KubeProxy() == nil {
+				t.Fatalf("expected non-nil KubeProxy")
+			}
+			if actual := tf.KubeProxyEnabled(); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_IsPrivateTopology(t *testing.T) {
+	tests := []struct {
+		desc     string
+		topology *kops.TopologySpec
+		expected string
+	}{
+		{
+			desc:     "nil topology",
+			topology: nil,
+			expected: "false",
+		},
+		{
+			desc:     "public masters",
+			topology: &kops.TopologySpec{Masters: kops.TopologyPublic},
+			expected: "false",
+		},
+		{
+			desc:     "private masters",
+			topology: &kops.TopologySpec{Masters: kops.TopologyPrivate},
+			expected: "true",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{
+					Spec: kops.ClusterSpec{Topology: test.topology},
+				},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(`{{ IsPrivateTopology }}`))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("unexpected output: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_HasAdmissionPlugin(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				KubeAPIServer: &kops.KubeAPIServerConfig{
+					EnableAdmissionPlugins:  []string{"NodeRestriction", "PodSecurityPolicy"},
+					DisableAdmissionPlugins: []string{"PersistentVolumeLabel"},
+				},
+			},
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	cases := []struct {
+		template string
+		expected string
+	}{
+		{`{{ HasAdmissionPlugin "NodeRestriction" }}`, "true"},
+		{`{{ HasAdmissionPlugin "PersistentVolumeLabel" }}`, "false"},
+		{`{{ HasDisabledAdmissionPlugin "PersistentVolumeLabel" }}`, "true"},
+		{`{{ HasDisabledAdmissionPlugin "NodeRestriction" }}`, "false"},
+	}
+	for _, c := range cases {
+		tpl := template.Must(template.New("admissionplugin").Funcs(funcMap).Parse(c.template))
+		var buffer bytes.Buffer
+		if err := tpl.Execute(&buffer, nil); err != nil {
+			t.Fatalf("unexpected error from %q: %v", c.template, err)
+		}
+		if buffer.String() != c.expected {
+			t.Errorf("unexpected result from %q.  expected %q, got %q", c.template, c.expected, buffer.String())
+		}
+	}
+
+	// Nil KubeAPIServer must not panic
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	if tf.HasAdmissionPlugin("NodeRestriction") || tf.HasDisabledAdmissionPlugin("NodeRestriction") {
+		t.Errorf("expected false for nil KubeAPIServer config")
+	}
+}
+
+func Test_TemplateFunctions_KubeAPIServerFlag(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				KubeAPIServer: &kops.KubeAPIServerConfig{
+					EnableAdmissionPlugins: []string{"NodeRestriction", "PodSecurityPolicy"},
+				},
+			},
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tpl := template.Must(template.New("apiserverflag").Funcs(funcMap).Parse(
+		`{{ KubeAPIServerFlag "enable-admission-plugins" }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "NodeRestriction,PodSecurityPolicy" {
+		t.Errorf("unexpected flag value: %q", buffer.String())
+	}
+
+	// A flag that is unset in the spec must fail the render, even when the
+	// whole KubeAPIServer section is nil
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	funcMap = make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+	tpl = template.Must(template.New("apiserverflagmiss").Funcs(funcMap).Parse(
+		`{{ KubeAPIServerFlag "enable-admission-plugins" }}`))
+	buffer.Reset()
+	if err := tpl.Execute(&buffer, nil); err == nil {
+		t.Errorf("expected error for unset flag, got output %q", buffer.String())
+	}
+
+	// The nil-safe accessor still yields an empty struct for direct field reads
+	tpl = template.Must(template.New("apiserverempty").Funcs(funcMap).Parse(
+		`{{ len KubeAPIServer.EnableAdmissionPlugins }}`))
+	buffer.Reset()
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "0" {
+		t.Errorf("unexpected output: %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_CNIAsset(t *testing.T) {
+	tests := []struct {
+		kubernetesVersion string
+		fileRepository    string
+		expectedURL       string
+		expectedHash      string
+		expectError       bool
+	}{
+		{
+			kubernetesVersion: "1.16.3",
+			expectedURL:       "https://storage.googleapis.com/kubernetes-release/network-plugins/cni-plugins-amd64-v0.7.5.tgz",
+			expectedHash:      "3ca15c0a18ee830520cf3a95408be826cbd255a1535a38e0be9608b25ad8bf64",
+		},
+		{
+			kubernetesVersion: "1.18.0",
+			expectedURL:       "https://storage.googleapis.com/k8s-artifacts-cni/release/v0.8.5/cni-plugins-linux-amd64-v0.8.5.tgz",
+			expectedHash:      "bd682ffcf701e8f83283cdff7281aad0c83b02a56084d6e601216210732833f9",
+		},
+		{
+			kubernetesVersion: "1.16.3",
+			fileRepository:    "https://mirror.example.com/kops",
+			expectedURL:       "https://mirror.example.com/kops/kubernetes-release/network-plugins/cni-plugins-amd64-v0.7.5.tgz",
+			expectedHash:      "3ca15c0a18ee830520cf3a95408be826cbd255a1535a38e0be9608b25ad8bf64",
+		},
+		{
+			kubernetesVersion: "not-a-version",
+			expectError:       true,
+		},
+	}
+	for _, test := range tests {
+		cluster := &kops.Cluster{}
+		cluster.Spec.KubernetesVersion = test.kubernetesVersion
+		if test.fileRepository != "" {
+			cluster.Spec.Assets = &kops.Assets{
+				FileRepository: fi.String(test.fileRepository),
+			}
+		}
+		tf := &TemplateFunctions{cluster: cluster}
+
+		actualURL, err := tf.CNIAssetURL()
+		if test.expectError {
+			if err == nil {
+				t.Errorf("expected error for version %q, got %q", test.kubernetesVersion, actualURL)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for version %q: %v", test.kubernetesVersion, err)
+			continue
+		}
+		if actualURL != test.expectedURL {
+			t.Errorf("unexpected URL for version %q: expected %q, got %q", test.kubernetesVersion, test.expectedURL, actualURL)
+		}
+
+		actualHash, err := tf.CNIAssetHash()
+		if err != nil {
+			t.Errorf("unexpected error for version %q: %v", test.kubernetesVersion, err)
+			continue
+		}
+		if actualHash != test.expectedHash {
+			t.Errorf("unexpected hash for version %q: expected %q, got %q", test.kubernetesVersion, test.expectedHash, actualHash)
+		}
+	}
+}
+
+func Test_TemplateFunctions_InstanceGroupMachineType(t *testing.T) {
+	ig := &kops.InstanceGroup{}
+	ig.ObjectMeta.Name = "nodes"
+	ig.Spec.Role = kops.InstanceGroupRoleNode
+	ig.Spec.MachineType = "m5.large"
+
+	noType := &kops.InstanceGroup{}
+	noType.ObjectMeta.Name = "untyped"
+	noType.Spec.Role = kops.InstanceGroupRoleNode
+
+	tf := &TemplateFunctions{
+		cluster:        &kops.Cluster{},
+		instanceGroups: []*kops.InstanceGroup{ig, noType},
+	}
+
+	actual, err := tf.InstanceGroupMachineType("nodes")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual != "m5.large" {
+		t.Errorf("unexpected machine type: %q", actual)
+	}
+
+	if _, err := tf.InstanceGroupMachineType("untyped"); err == nil {
+		t.Errorf("expected error for IG without a machine type")
+	}
+	if _, err := tf.InstanceGroupMachineType("missing"); err == nil {
+		t.Errorf("expected error for unknown instance group")
+	}
+}
+
+func Test_TemplateFunctions_EtcdVersion(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				EtcdClusters: []*kops.EtcdClusterSpec{
+					{Name: "main", Version: "3.3.10"},
+					{Name: "events"},
+				},
+			},
+		},
+	}
+
+	version, err := tf.EtcdVersion("main")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version != "3.3.10" {
+		t.Errorf("unexpected version: %q", version)
+	}
+
+	if _, err := tf.EtcdVersion("events"); err == nil {
+		t.Errorf("expected error for etcd cluster without a version")
+	}
+	if _, err := tf.EtcdVersion("missing"); err == nil {
+		t.Errorf("expected error for unknown etcd cluster")
+	}
+}
+
+func Test_TemplateFunctions_Project(t *testing.T) {
+	// GCE with a project
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderGCE),
+				Project:       "my-project",
+			},
+		},
+	}
+	project, err := tf.Project()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if project != "my-project" {
+		t.Errorf("unexpected project: %q", project)
+	}
+	argv, err := tf.ExternalDnsArgv()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found := false
+	for _, arg := range argv {
+		if arg == "--google-project=my-project" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected --google-project flag in argv %v", argv)
+	}
+
+	// GCE without a project must fail at update time
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderGCE),
+			},
+		},
+	}
+	if _, err := tf.Project(); err == nil {
+		t.Errorf("expected error for gce cluster without a project")
+	}
+	if _, err := tf.ExternalDnsArgv(); err == nil {
+		t.Errorf("expected error from ExternalDnsArgv for gce cluster without a project")
+	}
+
+	// Other clouds return the empty string harmlessly
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderAWS),
+			},
+		},
+	}
+	project, err = tf.Project()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if project != "" {
+		t.Errorf("unexpected project: %q", project)
+	}
+}
+
+func Test_TemplateFunctions_ContainerRuntime(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				ContainerRuntime: "containerd",
+			},
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{ ContainerRuntime }}`, "containerd"},
+		{`{{ if IsContainerRuntime "containerd" }}yes{{ end }}`, "yes"},
+		{`{{ if IsContainerRuntime "docker" }}yes{{ else }}no{{ end }}`, "no"},
+	}
+	for _, test := range tests {
+		tpl := template.Must(template.New("runtime").Funcs(funcMap).Parse(test.template))
+		var buffer bytes.Buffer
+		if err := tpl.Execute(&buffer, nil); err != nil {
+			t.Fatalf("unexpected error from %q: %v", test.template, err)
+		}
+		if buffer.String() != test.expected {
+			t.Errorf("unexpected result from %q.  expected %q, got %q", test.template, test.expected, buffer.String())
+		}
+	}
+}
+
+func Test_TemplateFunctions_Docker(t *testing.T) {
+	// The accessor returns an empty struct when docker config is unset
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	if tf.Docker() == nil {
+		t.Fatalf("expected non-nil Docker for nil spec field")
+	}
+
+	docker := &kops.DockerConfig{
+		LogDriver: fi.String("json-file"),
+	}
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Docker: docker,
+			},
+		},
+	}
+	if tf.Docker() != docker {
+		t.Errorf("expected accessor to return the spec's Docker config")
+	}
+}
+
+func Test_TemplateFunctions_UseEtcdTLS(t *testing.T) {
+	tests := []struct {
+		desc           string
+		etcdClusters   []*kops.EtcdClusterSpec
+		expected       bool
+		expectedScheme string
+		expectError    bool
+	}{
+		{
+			desc: "tls disabled",
+			etcdClusters: []*kops.EtcdClusterSpec{
+				{Name: "main"},
+				{Name: "events"},
+			},
+			expected:       false,
+			expectedScheme: "http",
+		},
+		{
+			desc: "tls enabled",
+			etcdClusters: []*kops.EtcdClusterSpec{
+				{Name: "main", EnableEtcdTLS: true},
+				{Name: "events", EnableEtcdTLS: true},
+			},
+			expected:       true,
+			expectedScheme: "https",
+		},
+		{
+			desc: "mixed configuration",
+			etcdClusters: []*kops.EtcdClusterSpec{
+				{Name: "main", EnableEtcdTLS: true},
+				{Name: "events"},
+			},
+			expectError: true,
+		},
+		{
+			desc:           "no etcd clusters",
+			expected:       false,
+			expectedScheme: "http",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{
+					Spec: kops.ClusterSpec{
+						EtcdClusters: test.etcdClusters,
+					},
+				},
+			}
+			actual, err := tf.UseEtcdTLS()
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got %v", actual)
+				}
+				if _, err := tf.EtcdScheme(); err == nil {
+					t.Errorf("expected error from EtcdScheme")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("unexpected UseEtcdTLS: expected %v, got %v", test.expected, actual)
+			}
+			scheme, err := tf.EtcdScheme()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if scheme != test.expectedScheme {
+				t.Errorf("unexpected EtcdScheme: expected %q, got %q", test.expectedScheme, scheme)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_DnsControllerArgvInternalDNS(t *testing.T) {
+	tests := []struct {
+		desc          string
+		cloudProvider kops.CloudProviderID
+		dnsType       kops.DNSType
+		dnsZone       string
+		expected      []string
+		expectedError error
+	}{
+		{
+			desc:          "aws public",
+			cloudProvider: kops.CloudProviderAWS,
+			dnsType:       kops.DNSTypePublic,
+			expected: []string{
+				"/usr/bin/dns-controller",
+				"--watch-ingress=false",
+				"--dns=aws-route53",
+				"--zone=*/*",
+				"-v=2",
+			},
+		},
+		{
+			desc:          "aws private restricts to the configured zone",
+			cloudProvider: kops.CloudProviderAWS,
+			dnsType:       kops.DNSTypePrivate,
+			dnsZone:       "Z1234567890",
+			expected: []string{
+				"/usr/bin/dns-controller",
+				"--watch-ingress=false",
+				"--dns=aws-route53",
+				"--zone=*/Z1234567890",
+				"-v=2",
+			},
+		},
+		{
+			desc:          "aws private without a zone",
+			cloudProvider: kops.CloudProviderAWS,
+			dnsType:       kops.DNSTypePrivate,
+			expectedError: fmt.Errorf("spec.dnsZone is required for a private DNS topology"),
+		},
+		{
+			desc:          "gce public",
+			cloudProvider: kops.CloudProviderGCE,
+			dnsType:       kops.DNSTypePublic,
+			expected: []string{
+				"/usr/bin/dns-controller",
+				"--watch-ingress=false",
+				"--dns=google-clouddns",
+				"--zone=*/*",
+				"-v=2",
+			},
+		},
+		{
+			desc:          "gce private restricts to the configured zone",
+			cloudProvider: kops.CloudProviderGCE,
+			dnsType:       kops.DNSTypePrivate,
+			dnsZone:       "internal.example.com",
+			expected: []string{
+				"/usr/bin/dns-controller",
+				"--watch-ingress=false",
+				"--dns=google-clouddns",
+				"--zone=internal.example.com",
+				"-v=2",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{
+					Spec: kops.ClusterSpec{
+						CloudProvider:      string(test.cloudProvider),
+						MasterInternalName: "api.internal.mycluster.example.com",
+						DNSZone:            test.dnsZone,
+						Topology: &kops.TopologySpec{
+							DNS: &kops.DNSSpec{
+								Type: test.dnsType,
+							},
+						},
+					},
+				},
+			}
+
+			expectedInternal := test.dnsType == kops.DNSTypePrivate
+			if actual := tf.IsInternalDNS(); actual != expectedInternal {
+				t.Errorf("unexpected IsInternalDNS: expected %v, got %v", expectedInternal, actual)
+			}
+
+			argv, err := tf.DnsControllerArgv()
+			if test.expectedError != nil {
+				if err == nil || err.Error() != test.expectedError.Error() {
+					t.Fatalf("expected error %q, got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(argv, test.expected) {
+				t.Errorf("unexpected argv.\nexpected %v\ngot      %v", test.expected, argv)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_MasterKubeletConfig(t *testing.T) {
+	// The accessor returns an empty struct when master kubelet config is unset
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	if tf.MasterKubeletConfig() == nil {
+		t.Fatalf("expected non-nil MasterKubeletConfig for nil spec field")
+	}
+
+	masterKubelet := &kops.KubeletConfigSpec{
+		RegisterSchedulable: fi.Bool(false),
+	}
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Kubelet:       &kops.KubeletConfigSpec{MaxPods: fi.Int32(110)},
+				MasterKubelet: masterKubelet,
+			},
+		},
+	}
+	if tf.MasterKubeletConfig() != masterKubelet {
+		t.Errorf("expected accessor to return the spec's MasterKubelet config")
+	}
+	// MasterKubeletConfig does not inherit fields from the node kubelet config
+	if tf.MasterKubeletConfig().MaxPods != nil {
+		t.Errorf("expected MasterKubeletConfig not to inherit node kubelet fields")
+	}
+}
+
+func Test_TemplateFunctions_TopologyPredicates(t *testing.T) {
+	tests := []struct {
+		desc              string
+		spec              kops.ClusterSpec
+		expectedPrivate   bool
+		expectedBastion   bool
+		expectedPublicAPI bool
+	}{
+		{
+			desc:              "public cluster",
+			spec:              kops.ClusterSpec{},
+			expectedPrivate:   false,
+			expectedBastion:   false,
+			expectedPublicAPI: true,
+		},
+		{
+			desc: "private with bastion",
+			spec: kops.ClusterSpec{
+				Topology: &kops.TopologySpec{
+					Masters: kops.TopologyPrivate,
+					Nodes:   kops.TopologyPrivate,
+					Bastion: &kops.BastionSpec{},
+				},
+				API: &kops.AccessSpec{
+					LoadBalancer: &kops.LoadBalancerAccessSpec{
+						Type: kops.LoadBalancerTypeInternal,
+					},
+				},
+			},
+			expectedPrivate:   true,
+			expectedBastion:   true,
+			expectedPublicAPI: false,
+		},
+		{
+			desc: "private without bastion",
+			spec: kops.ClusterSpec{
+				Topology: &kops.TopologySpec{
+					Masters: kops.TopologyPrivate,
+					Nodes:   kops.TopologyPrivate,
+				},
+				API: &kops.AccessSpec{
+					LoadBalancer: &kops.LoadBalancerAccessSpec{
+						Type: kops.LoadBalancerTypePublic,
+					},
+				},
+			},
+			expectedPrivate:   true,
+			expectedBastion:   false,
+			expectedPublicAPI: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{Spec: test.spec},
+			}
+			if actual := tf.IsPrivateTopology(); actual != test.expectedPrivate {
+				t.Errorf("unexpected IsPrivateTopology: expected %v, got %v", test.expectedPrivate, actual)
+			}
+			if actual := tf.UsesBastion(); actual != test.expectedBastion {
+				t.Errorf("unexpected UsesBastion: expected %v, got %v", test.expectedBastion, actual)
+			}
+			if actual := tf.IsPublicAPI(); actual != test.expectedPublicAPI {
+				t.Errorf("unexpected IsPublicAPI: expected %v, got %v", test.expectedPublicAPI, actual)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_KubeletConfig(t *testing.T) {
+	// The accessor returns an empty struct when kubelet config is unset
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	if tf.KubeletConfig() == nil {
+		t.Fatalf("expected non-nil KubeletConfig for nil spec field")
+	}
+
+	kubelet := &kops.KubeletConfigSpec{
+		MaxPods: fi.Int32(110),
+	}
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Kubelet: kubelet,
+			},
+		},
+	}
+	if tf.KubeletConfig() != kubelet {
+		t.Errorf("expected accessor to return the spec's Kubelet config")
+	}
+
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+	tpl := template.Must(template.New("kubeletconfig").Funcs(funcMap).Parse(
+		`{{ KubeletConfig.MaxPods }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "110" {
+		t.Errorf("unexpected output: %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_GossipSeeds(t *testing.T) {
+	// A gossip cluster without explicit configuration seeds from localhost
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				MasterInternalName: "api.internal.mycluster.k8s.local",
+			},
+		},
+	}
+	seeds, err := tf.GossipSeeds()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(seeds, []string{"127.0.0.1:3999"}) {
+		t.Errorf("unexpected seeds: %v", seeds)
+	}
+
+	// Explicit seeds from the spec, including the secondary protocol
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				MasterInternalName: "api.internal.mycluster.k8s.local",
+				DNSControllerGossipConfig: &kops.DNSControllerGossipConfig{
+					Seed: fi.String("10.0.0.1:3999"),
+					Secondary: &kops.DNSControllerGossipConfig{
+						Seed: fi.String("10.0.0.1:4000"),
+					},
+				},
+			},
+		},
+	}
+	seeds, err = tf.GossipSeeds()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(seeds, []string{"10.0.0.1:3999", "10.0.0.1:4000"}) {
+		t.Errorf("unexpected seeds: %v", seeds)
+	}
+
+	// A non-gossip cluster has no seeds
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				MasterInternalName: "api.internal.mycluster.example.com",
+			},
+		},
+	}
+	if _, err := tf.GossipSeeds(); err == nil {
+		t.Errorf("expected error for non-gossip cluster")
+	}
+}
+
+func Test_TemplateFunctions_Authentication(t *testing.T) {
+	authTemplate := `{{ if UseKopeioAuthentication }}--authentication-token-webhook-config-file=/etc/kubernetes/authn.config{{ end }}`
+
+	tests := []struct {
+		desc           string
+		authentication *kops.AuthenticationSpec
+		expected       string
+	}{
+		{
+			desc:           "nil authentication",
+			authentication: nil,
+			expected:       "",
+		},
+		{
+			desc:           "empty authentication",
+			authentication: &kops.AuthenticationSpec{},
+			expected:       "",
+		},
+		{
+			desc: "kopeio",
+			authentication: &kops.AuthenticationSpec{
+				Kopeio: &kops.KopeioAuthenticationSpec{},
+			},
+			expected: "--authentication-token-webhook-config-file=/etc/kubernetes/authn.config",
+		},
+		{
+			desc: "aws",
+			authentication: &kops.AuthenticationSpec{
+				Aws: &kops.AwsAuthenticationSpec{},
+			},
+			expected: "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{
+					Spec: kops.ClusterSpec{
+						Authentication: test.authentication,
+					},
+				},
+			}
+			if tf.Authentication() == nil {
+				t.Fatalf("expected non-nil Authentication")
+			}
+
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+			tpl := template.Must(template.New("authn").Funcs(funcMap).Parse(authTemplate))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, nil); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("unexpected output: expected %q, got %q", test.expected, buffer.String())
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_AuthorizationMode(t *testing.T) {
+	tests := []struct {
+		desc          string
+		spec          kops.ClusterSpec
+		expectedMode  string
+		expectedsRBAC bool
+	}{
+		{
+			desc:         "nil authorization",
+			spec:         kops.ClusterSpec{},
+			expectedMode: "AlwaysAllow",
+		},
+		{
+			desc: "empty authorization",
+			spec: kops.ClusterSpec{
+				Authorization: &kops.AuthorizationSpec{},
+			},
+			expectedMode: "AlwaysAllow",
+		},
+		{
+			desc: "alwaysAllow",
+			spec: kops.ClusterSpec{
+				Authorization: &kops.AuthorizationSpec{
+					AlwaysAllow: &kops.AlwaysAllowAuthorizationSpec{},
+				},
+			},
+			expectedMode: "AlwaysAllow",
+		},
+		{
+			desc: "rbac",
+			spec: kops.ClusterSpec{
+				Authorization: &kops.AuthorizationSpec{
+					RBAC: &kops.RBACAuthorizationSpec{},
+				},
+			},
+			expectedMode:  "RBAC",
+			expectedsRBAC: true,
+		},
+		{
+			desc: "rendered apiserver flag wins",
+			spec: kops.ClusterSpec{
+				Authorization: &kops.AuthorizationSpec{
+					RBAC: &kops.RBACAuthorizationSpec{},
+				},
+				KubeAPIServer: &kops.KubeAPIServerConfig{
+					AuthorizationMode: fi.String("Node,RBAC"),
+				},
+			},
+			expectedMode:  "Node,RBAC",
+			expectedsRBAC: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{Spec: test.spec},
+			}
+			if actual := tf.AuthorizationMode(); actual != test.expectedMode {
+				t.Errorf("unexpected AuthorizationMode: expected %q, got %q", test.expectedMode, actual)
+			}
+			if actual := tf.UseRBAC(); actual != test.expectedsRBAC {
+				t.Errorf("unexpected UseRBAC: expected %v, got %v", test.expectedsRBAC, actual)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_NoProxy(t *testing.T) {
+	// A cluster with a proxy configured merges the spec excludes
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				MasterInternalName:    "api.internal.mycluster.example.com",
+				ServiceClusterIPRange: "100.64.0.0/13",
+				NonMasqueradeCIDR:     "100.64.0.0/10",
+				EgressProxy: &kops.EgressProxySpec{
+					HTTPProxy: kops.HTTPProxy{
+						Host: "proxy.corp.example.com",
+						Port: 3128,
+					},
+					ProxyExcludes: "internal.example.com, 169.254.169.254,.cluster.local",
+				},
+			},
+		},
+	}
+	expected := "api.internal.mycluster.example.com,.cluster.local,100.64.0.0/13,100.64.0.0/10,internal.example.com,169.254.169.254"
+	if actual := tf.NoProxy(); actual != expected {
+		t.Errorf("unexpected NoProxy.  expected %q, got %q", expected, actual)
+	}
+
+	// Repeated calls must produce identical output
+	if tf.NoProxy() != expected {
+		t.Errorf("expected deterministic NoProxy output")
+	}
+
+	// A cluster without a proxy still gets the in-cluster entries
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				MasterInternalName:    "api.internal.mycluster.example.com",
+				ServiceClusterIPRange: "100.64.0.0/13",
+			},
+		},
+	}
+	expected = "api.internal.mycluster.example.com,.cluster.local,100.64.0.0/13"
+	if actual := tf.NoProxy(); actual != expected {
+		t.Errorf("unexpected NoProxy.  expected %q, got %q", expected, actual)
+	}
+
+	// ProxyEnv is empty for clusters without an egress proxy
+	if envs := tf.ProxyEnv(); len(envs) != 0 {
+		t.Errorf("expected empty ProxyEnv, got %+v", envs)
+	}
+}
+
+func Test_TemplateFunctions_InstanceGroupImage(t *testing.T) {
+	ig := &kops.InstanceGroup{}
+	ig.ObjectMeta.Name = "nodes"
+	ig.Spec.Role = kops.InstanceGroupRoleNode
+	ig.Spec.Image = "ami-12345678"
+
+	noImage := &kops.InstanceGroup{}
+	noImage.ObjectMeta.Name = "plain"
+	noImage.Spec.Role = kops.InstanceGroupRoleNode
+
+	// An IG with an explicit image wins
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderAWS),
+			},
+		},
+		instanceGroups: []*kops.InstanceGroup{ig, noImage},
+	}
+	actual, err := tf.InstanceGroupImage("nodes")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual != "ami-12345678" {
+		t.Errorf("unexpected image: %q", actual)
+	}
+
+	// AWS has no channel-free default image, so an IG without one errors
+	if _, err := tf.InstanceGroupImage("plain"); err == nil {
+		t.Errorf("expected error for IG without an image on AWS")
+	}
+
+	// DO has a static default image to fall back on
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				CloudProvider: string(kops.CloudProviderDO),
+			},
+		},
+		instanceGroups: []*kops.InstanceGroup{noImage},
+	}
+	actual, err = tf.InstanceGroupImage("plain")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual == "" {
+		t.Errorf("expected cloudprovider default image")
+	}
+
+	if _, err := tf.InstanceGroupImage("missing"); err == nil {
+		t.Errorf("expected error for unknown instance group")
+	}
+}
+
+func Test_TemplateFunctions_InstanceGroupSizes(t *testing.T) {
+	ig := &kops.InstanceGroup{}
+	ig.ObjectMeta.Name = "nodes"
+	ig.Spec.Role = kops.InstanceGroupRoleNode
+	ig.Spec.MinSize = fi.Int32(2)
+	ig.Spec.MaxSize = fi.Int32(10)
+
+	unsized := &kops.InstanceGroup{}
+	unsized.ObjectMeta.Name = "unsized"
+	unsized.Spec.Role = kops.InstanceGroupRoleNode
+
+	tf := &TemplateFunctions{
+		cluster:        &kops.Cluster{},
+		instanceGroups: []*kops.InstanceGroup{ig, unsized},
+	}
+
+	minSize, err := tf.InstanceGroupMinSize("nodes")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if minSize != 2 {
+		t.Errorf("unexpected min size: %d", minSize)
+	}
+
+	maxSize, err := tf.InstanceGroupMaxSize("nodes")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maxSize != 10 {
+		t.Errorf("unexpected max size: %d", maxSize)
+	}
+
+	if _, err := tf.InstanceGroupMinSize("unsized"); err == nil {
+		t.Errorf("expected error for IG without a minimum size")
+	}
+	if _, err := tf.InstanceGroupMaxSize("unsized"); err == nil {
+		t.Errorf("expected error for IG without a maximum size")
+	}
+	if _, err := tf.InstanceGroupMinSize("missing"); err == nil {
+		t.Errorf("expected error for unknown instance group")
+	}
+}
+
+func Test_TemplateFunctions_InstanceGroupSubnets(t *testing.T) {
+	ig := &kops.InstanceGroup{}
+	ig.ObjectMeta.Name = "nodes"
+	ig.Spec.Role = kops.InstanceGroupRoleNode
+	ig.Spec.Subnets = []string{"us-test-1b", "us-test-1a"}
+
+	badIG := &kops.InstanceGroup{}
+	badIG.ObjectMeta.Name = "orphan"
+	badIG.Spec.Role = kops.InstanceGroupRoleNode
+	badIG.Spec.Subnets = []string{"missing"}
+
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Subnets: []kops.ClusterSubnetSpec{
+					{Name: "us-test-1a", CIDR: "172.20.32.0/19", Zone: "us-test-1a"},
+					{Name: "us-test-1b", CIDR: "172.20.64.0/19", Zone: "us-test-1b"},
+					{Name: "utility-us-test-1a", CIDR: "172.20.0.0/22", Zone: "us-test-1a"},
+				},
+			},
+		},
+		instanceGroups: []*kops.InstanceGroup{ig, badIG},
+	}
+
+	subnets, err := tf.InstanceGroupSubnets("nodes")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(subnets) != 2 {
+		t.Fatalf("expected 2 subnets, got %d", len(subnets))
+	}
+	// Order follows the IG's subnet list
+	if subnets[0].Name != "us-test-1b" || subnets[1].Name != "us-test-1a" {
+		t.Errorf("unexpected subnets: %q, %q", subnets[0].Name, subnets[1].Name)
+	}
+	if subnets[0].CIDR != "172.20.64.0/19" {
+		t.Errorf("expected full subnet spec, got %+v", subnets[0])
+	}
+
+	if _, err := tf.InstanceGroupSubnets("unknown"); err == nil {
+		t.Errorf("expected error for unknown instance group")
+	}
+	if _, err := tf.InstanceGroupSubnets("orphan"); err == nil {
+		t.Errorf("expected error for IG referencing a missing subnet")
+	}
+}
+
+func Test_TemplateFunctions_NetworkingProvider(t *testing.T) {
+	tests := []struct {
+		networking *kops.NetworkingSpec
+		expected   string
+		usesCNI    bool
+	}{
+		{&kops.NetworkingSpec{Classic: &kops.ClassicNetworkingSpec{}}, "classic", false},
+		{&kops.NetworkingSpec{Kubenet: &kops.KubenetNetworkingSpec{}}, "kubenet", false},
+		{&kops.NetworkingSpec{External: &kops.ExternalNetworkingSpec{}}, "external", true},
+		{&kops.NetworkingSpec{CNI: &kops.CNINetworkingSpec{}}, "cni", true},
+		{&kops.NetworkingSpec{Kopeio: &kops.KopeioNetworkingSpec{}}, "kopeio", true},
+		{&kops.NetworkingSpec{Weave: &kops.WeaveNetworkingSpec{}}, "weave", true},
+		{&kops.NetworkingSpec{Flannel: &kops.FlannelNetworkingSpec{}}, "flannel", true},
+		{&kops.NetworkingSpec{Calico: &kops.CalicoNetworkingSpec{}}, "calico", true},
+		{&kops.NetworkingSpec{Canal: &kops.CanalNetworkingSpec{}}, "canal", true},
+		{&kops.NetworkingSpec{Kuberouter: &kops.KuberouterNetworkingSpec{}}, "kuberouter", true},
+		{&kops.NetworkingSpec{Romana: &kops.RomanaNetworkingSpec{}}, "romana", true},
+		{&kops.NetworkingSpec{AmazonVPC: &kops.AmazonVPCNetworkingSpec{}}, "amazonvpc", true},
+		{&kops.NetworkingSpec{Cilium: &kops.CiliumNetworkingSpec{}}, "cilium", true},
+		{&kops.NetworkingSpec{LyftVPC: &kops.LyftVPCNetworkingSpec{}}, "lyftvpc", true},
+		{&kops.NetworkingSpec{GCE: &kops.GCENetworkingSpec{}}, "gce", true},
+	}
+	for _, test := range tests {
+		tf := &TemplateFunctions{
+			cluster: &kops.Cluster{
+				Spec: kops.ClusterSpec{
+					Networking: test.networking,
+				},
+			},
+		}
+		actual, err := tf.NetworkingProvider()
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.expected, err)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("unexpected provider: expected %q, got %q", test.expected, actual)
+		}
+		if tf.UsesCNI() != test.usesCNI {
+			t.Errorf("UsesCNI for %q: expected %v, got %v", test.expected, test.usesCNI, tf.UsesCNI())
+		}
+	}
+
+	// No networking block at all
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	if _, err := tf.NetworkingProvider(); err == nil {
+		t.Errorf("expected error for nil networking spec")
+	}
+
+	// Networking block with no provider selected
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Networking: &kops.NetworkingSpec{},
+			},
+		},
+	}
+	if _, err := tf.NetworkingProvider(); err == nil {
+		t.Errorf("expected error for empty networking spec")
+	}
+
+	// Multiple providers selected
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Networking: &kops.NetworkingSpec{
+					Calico: &kops.CalicoNetworkingSpec{},
+					Weave:  &kops.WeaveNetworkingSpec{},
+				},
+			},
+		},
+	}
+	if _, err := tf.NetworkingProvider(); err == nil {
+		t.Errorf("expected error for multiple networking providers")
+	} else if !strings.Contains(err.Error(), "calico, weave") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_TemplateFunctions_CloudConfig(t *testing.T) {
+	// The accessor returns an empty struct when cloudConfig is unset
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+	if tf.CloudConfig() == nil {
+		t.Fatalf("expected non-nil CloudConfig for nil spec field")
+	}
+
+	cloudConfig := &kops.CloudConfiguration{
+		Multizone: fi.Bool(true),
+	}
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				CloudConfig: cloudConfig,
+			},
+		},
+	}
+	if tf.CloudConfig() != cloudConfig {
+		t.Errorf("expected accessor to return the spec's CloudConfig")
+	}
+
+	// A vSphere cluster without a cloudConfig block must yield an error
+	// from DnsControllerArgv, not a panic
+	tf = &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				CloudProvider:      string(kops.CloudProviderVSphere),
+				MasterInternalName: "api.internal.mycluster.example.com",
+			},
+		},
+	}
+	if _, err := tf.DnsControllerArgv(); err == nil {
+		t.Errorf("expected error for vsphere cluster without cloudConfig")
+	} else if !strings.Contains(err.Error(), "spec.cloudConfig.vSphereCoreDNSServer") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_TemplateFunctions_HasFeatureGate(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Kubelet: &kops.KubeletConfigSpec{
+					FeatureGates: map[string]string{
+						"PodPriority":  "true",
+						"Accelerators": "false",
+					},
+				},
+				KubeAPIServer: &kops.KubeAPIServerConfig{
+					FeatureGates: map[string]string{
+						"PodPriority": "true",
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		desc        string
+		component   string
+		gate        string
+		expected    bool
+		expectError bool
+	}{
+		{desc: "enabled gate", component: "kubelet", gate: "PodPriority", expected: true},
+		{desc: "gate explicitly set to false", component: "kubelet", gate: "Accelerators", expected: false},
+		{desc: "unset gate", component: "kubelet", gate: "CSIMigration", expected: false},
+		{desc: "apiserver gate", component: "kubeAPIServer", gate: "PodPriority", expected: true},
+		{desc: "component config is nil", component: "kubeScheduler", gate: "PodPriority", expected: false},
+		{desc: "unknown component", component: "kubeProxy", gate: "PodPriority", expectError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			actual, err := tf.HasFeatureGate(test.component, test.gate)
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("HasFeatureGate(%q, %q): expected %v, got %v", test.component, test.gate, test.expected, actual)
+			}
+		})
+	}
+
+	// FeatureGateEnabled is registered as an alias for HasFeatureGate
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+	if funcMap["FeatureGateEnabled"] == nil {
+		t.Fatalf("FeatureGateEnabled is not registered")
+	}
+	tpl := template.Must(template.New("featuregate").Funcs(funcMap).Parse(
+		`{{ FeatureGateEnabled "kubelet" "PodPriority" }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "true" {
+		t.Errorf("unexpected result from FeatureGateEnabled: %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_MasterCount(t *testing.T) {
+	masterIG := func(name string, size *int32) *kops.InstanceGroup {
+		ig := &kops.InstanceGroup{}
+		ig.ObjectMeta.Name = name
+		ig.Spec.Role = kops.InstanceGroupRoleMaster
+		ig.Spec.MinSize = size
+		return ig
+	}
+	nodeIG := &kops.InstanceGroup{}
+	nodeIG.ObjectMeta.Name = "nodes"
+	nodeIG.Spec.Role = kops.InstanceGroupRoleNode
+	nodeIG.Spec.MinSize = fi.Int32(10)
+
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+		instanceGroups: []*kops.InstanceGroup{
+			masterIG("master-1a", fi.Int32(1)),
+			masterIG("master-1b", fi.Int32(2)),
+			nodeIG,
+		},
+	}
+
+	count, err := tf.MasterCount()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected master count 3, got %d", count)
+	}
+
+	nodes, err := tf.NodeCount()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nodes != 10 {
+		t.Errorf("expected node count 10, got %d", nodes)
+	}
+
+	tf.instanceGroups = append(tf.instanceGroups, masterIG("master-1c", nil))
+	if _, err := tf.MasterCount(); err == nil {
+		t.Errorf("expected error for master IG without MinSize")
+	}
+
+	nodeIG.Spec.MinSize = nil
+	if _, err := tf.NodeCount(); err == nil {
+		t.Errorf("expected error for node IG without MinSize")
+	}
+}
+
+func Test_TemplateFunctions_IsHighlyAvailable(t *testing.T) {
+	masterIG := func(name string, size int32) *kops.InstanceGroup {
+		ig := &kops.InstanceGroup{}
+		ig.ObjectMeta.Name = name
+		ig.Spec.Role = kops.InstanceGroupRoleMaster
+		ig.Spec.MinSize = fi.Int32(size)
+		return ig
+	}
+
+	tests := []struct {
+		desc           string
+		instanceGroups []*kops.InstanceGroup
+		expected       bool
+	}{
+		{
+			desc:           "single master",
+			instanceGroups: []*kops.InstanceGroup{masterIG("master-1a", 1)},
+			expected:       false,
+		},
+		{
+			desc: "three masters",
+			instanceGroups: []*kops.InstanceGroup{
+				masterIG("master-1a", 1),
+				masterIG("master-1b", 1),
+				masterIG("master-1c", 1),
+			},
+			expected: true,
+		},
+		{
+			desc: "five masters",
+			instanceGroups: []*kops.InstanceGroup{
+				masterIG("master-1a", 3),
+				masterIG("master-1b", 2),
+			},
+			expected: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster:        &kops.Cluster{},
+				instanceGroups: test.instanceGroups,
+			}
+			if actual := tf.IsHighlyAvailable(); actual != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_AccessLists(t *testing.T) {
+	tests := []struct {
+		desc        string
+		input       []string
+		expected    []string
+		expectError bool
+	}{
+		{
+			desc:     "unset defaults to open",
+			input:    nil,
+			expected: []string{"0.0.0.0/0"},
+		},
+		{
+			desc:     "multiple entries preserved in order",
+			input:    []string{"10.1.0.0/16", "10.0.0.0/16"},
+			expected: []string{"10.1.0.0/16", "10.0.0.0/16"},
+		},
+		{
+			desc:     "duplicates removed, order preserved",
+			input:    []string{"10.1.0.0/16", "10.0.0.0/16", "10.1.0.0/16"},
+			expected: []string{"10.1.0.0/16", "10.0.0.0/16"},
+		},
+		{
+			desc:        "invalid entry",
+			input:       []string{"10.1.0.0/16", "not-a-cidr"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{
+					Spec: kops.ClusterSpec{
+						KubernetesAPIAccess: test.input,
+						SSHAccess:           test.input,
+					},
+				},
+			}
+
+			apiAccess, err := tf.KubernetesAPIAccess()
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got %v", apiAccess)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(apiAccess, test.expected) {
+					t.Errorf("unexpected KubernetesAPIAccess: %v instead of %v", apiAccess, test.expected)
+				}
+			}
+
+			sshAccess, err := tf.SSHAccess()
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got %v", sshAccess)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(sshAccess, test.expected) {
+				t.Errorf("unexpected SSHAccess: %v instead of %v", sshAccess, test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_APIServerURL(t *testing.T) {
+	tests := []struct {
+		desc     string
+		spec     kops.ClusterSpec
+		expected string
+	}{
+		{
+			desc: "real DNS name",
+			spec: kops.ClusterSpec{
+				MasterInternalName: "api.internal.mycluster.example.com",
+			},
+			expected: "https://api.internal.mycluster.example.com",
+		},
+		{
+			desc: "gossip-style name",
+			spec: kops.ClusterSpec{
+				MasterInternalName: "api.internal.mycluster.k8s.local",
+			},
+			expected: "https://api.internal.mycluster.k8s.local",
+		},
+		{
+			desc: "non-default secure port",
+			spec: kops.ClusterSpec{
+				MasterInternalName: "api.internal.mycluster.example.com",
+				KubeAPIServer: &kops.KubeAPIServerConfig{
+					SecurePort: 8443,
+				},
+			},
+			expected: "https://api.internal.mycluster.example.com:8443",
+		},
+		{
+			desc: "default secure port is omitted",
+			spec: kops.ClusterSpec{
+				MasterInternalName: "api.internal.mycluster.example.com",
+				KubeAPIServer: &kops.KubeAPIServerConfig{
+					SecurePort: 443,
+				},
+			},
+			expected: "https://api.internal.mycluster.example.com",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{Spec: test.spec},
+			}
+			if actual := tf.APIServerURL(); actual != test.expected {
+				t.Errorf("unexpected APIServerURL: %q instead of %q", actual, test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_GetEtcdClusterInTemplate(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				EtcdClusters: []*kops.EtcdClusterSpec{
+					{Name: "main"},
+				},
+			},
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tpl := template.Must(template.New("etcdlookup").Funcs(funcMap).Parse(`{{ (GetEtcdCluster "main").Name }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "main" {
+		t.Errorf("unexpected output: %q", buffer.String())
+	}
+
+	// A miss must fail the render with a clear error, not return a nil
+	// pointer that panics on first field access
+	tpl = template.Must(template.New("etcdmiss").Funcs(funcMap).Parse(`{{ (GetEtcdCluster "missing").Name }}`))
+	buffer.Reset()
+	if err := tpl.Execute(&buffer, nil); err == nil {
+		t.Errorf("expected error for missing etcd cluster, got output %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_EtcdClusterNames(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				EtcdClusters: []*kops.EtcdClusterSpec{
+					{Name: "main"},
+					{Name: "events"},
+				},
+			},
+		},
+	}
+	funcMap := make(template.FuncMap)
+	if err := tf.AddTo(funcMap, nil); err != nil {
+		t.Fatalf("error from AddTo: %v", err)
+	}
+
+	tpl := template.Must(template.New("etcdnames").Funcs(funcMap).Parse(`{{ range EtcdClusterNames }}[{{ . }}]{{ end }}`))
+	var buffer bytes.Buffer
+	if err := tpl.Execute(&buffer, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buffer.String() != "[events][main]" {
+		t.Errorf("expected sorted etcd cluster names, got %q", buffer.String())
+	}
+}
+
+func Test_TemplateFunctions_QuoteSquote(t *testing.T) {
+	tests := []struct {
+		desc        string
+		template    string
+		input       string
+		expected    string
+		expectError bool
+	}{
+		{
+			desc:     "Quote plain string",
+			template: `{{ Quote .Input }}`,
+			input:    "cluster.example.com",
+			expected: `"cluster.example.com"`,
+		},
+		{
+			desc:     "Quote escapes internal double quotes",
+			template: `{{ Quote .Input }}`,
+			input:    `say "hello"`,
+			expected: `"say \"hello\""`,
+		},
+		{
+			desc:        "Quote rejects newlines",
+			template:    `{{ Quote .Input }}`,
+			input:       "line1\nline2",
+			expectError: true,
+		},
+		{
+			desc:     "Squote plain string",
+			template: `{{ Squote .Input }}`,
+			input:    "us-east-1a",
+			expected: `'us-east-1a'`,
+		},
+		{
+			desc:     "Squote escapes internal single quotes",
+			template: `{{ Squote .Input }}`,
+			input:    "it's",
+			expected: `'it'\''s'`,
+		},
+		{
+			desc:        "Squote rejects null bytes",
+			template:    `{{ Squote .Input }}`,
+			input:       "a\x00b",
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			err := tpl.Execute(&buffer, map[string]string{"Input": test.input})
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got output %q", buffer.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_EtcdClusters(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				EtcdClusters: []*kops.EtcdClusterSpec{
+					{
+						Name: "main",
+						Members: []*kops.EtcdMemberSpec{
+							{Name: "a"}, {Name: "b"}, {Name: "c"},
+						},
+					},
+					{
+						Name: "events",
+						Members: []*kops.EtcdMemberSpec{
+							{Name: "a"}, {Name: "b"}, {Name: "c"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	clusters := tf.EtcdClusters()
+	var names []string
+	for _, etcd := range clusters {
+		names = append(names, etcd.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"events", "main"}) {
+		t.Errorf("expected name-sorted etcd clusters, got %v", names)
+	}
+
+	main, err := tf.GetEtcdCluster("main")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(main.Members) != 3 {
+		t.Errorf("unexpected member count: %d", len(main.Members))
+	}
+
+	_, err = tf.GetEtcdCluster("cilium")
+	if err == nil {
+		t.Fatalf("expected error for missing etcd cluster")
+	}
+	if !strings.Contains(err.Error(), "main") || !strings.Contains(err.Error(), "events") {
+		t.Errorf("expected available cluster names in error, got: %v", err)
+	}
+}
+
+func Test_TemplateFunctions_ClusterDNSIP(t *testing.T) {
+	tests := []struct {
+		desc        string
+		spec        kops.ClusterSpec
+		expected    string
+		expectError bool
+	}{
+		{
+			desc: "conventional 10th address of the default range",
+			spec: kops.ClusterSpec{
+				ServiceClusterIPRange: "100.64.0.0/13",
+			},
+			expected: "100.64.0.10",
+		},
+		{
+			desc: "explicit KubeDNS.ServerIP override",
+			spec: kops.ClusterSpec{
+				ServiceClusterIPRange: "100.64.0.0/13",
+				KubeDNS: &kops.KubeDNSConfig{
+					ServerIP: "100.64.0.53",
+				},
+			},
+			expected: "100.64.0.53",
+		},
+		{
+			desc: "range too small",
+			spec: kops.ClusterSpec{
+				ServiceClusterIPRange: "100.64.0.0/31",
+			},
+			expectError: true,
+		},
+		{
+			desc:        "range unset",
+			spec:        kops.ClusterSpec{},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{Spec: test.spec},
+			}
+			actual, err := tf.ClusterDNSIP()
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got %q", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("unexpected ClusterDNSIP: %q instead of %q", actual, test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_IndentCapital(t *testing.T) {
+	tests := []struct {
+		desc     string
+		template string
+		input    string
+		expected string
+	}{
+		{
+			desc:     "zero indent",
+			template: `{{ Indent 0 .Input }}`,
+			input:    "key: value",
+			expected: "key: value",
+		},
+		{
+			desc:     "single line",
+			template: `{{ Indent 2 .Input }}`,
+			input:    "key: value",
+			expected: "  key: value",
+		},
+		{
+			desc:     "multi-line indents every line including the first",
+			template: `{{ Indent 4 .Input }}`,
+			input:    "a: 1\nb: 2",
+			expected: "    a: 1\n    b: 2",
+		},
+		{
+			desc:     "Nindent prepends a newline",
+			template: `data:{{ Nindent 2 .Input }}`,
+			input:    "a: 1\nb: 2",
+			expected: "data:\n  a: 1\n  b: 2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{},
+			}
+			funcMap := make(template.FuncMap)
+			if err := tf.AddTo(funcMap, nil); err != nil {
+				t.Fatalf("error from AddTo: %v", err)
+			}
+
+			tpl := template.Must(template.New(test.desc).Funcs(funcMap).Parse(test.template))
+			var buffer bytes.Buffer
+			if err := tpl.Execute(&buffer, map[string]string{"Input": test.input}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buffer.String() != test.expected {
+				t.Errorf("rendered output differs: %q instead of %q", buffer.String(), test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_PodCIDR(t *testing.T) {
+	tests := []struct {
+		desc        string
+		spec        kops.ClusterSpec
+		expected    string
+		expectError bool
+	}{
+		{
+			desc: "explicit controller-manager cluster CIDR wins",
+			spec: kops.ClusterSpec{
+				NonMasqueradeCIDR: "100.64.0.0/10",
+				KubeControllerManager: &kops.KubeControllerManagerConfig{
+					ClusterCIDR: "100.96.0.0/11",
+				},
+			},
+			expected: "100.96.0.0/11",
+		},
+		{
+			desc: "falls back to NonMasqueradeCIDR",
+			spec: kops.ClusterSpec{
+				NonMasqueradeCIDR:     "100.64.0.0/10",
+				KubeControllerManager: &kops.KubeControllerManagerConfig{},
+			},
+			expected: "100.64.0.0/10",
+		},
+		{
+			desc: "falls back when controller-manager config is nil",
+			spec: kops.ClusterSpec{
+				NonMasqueradeCIDR: "100.64.0.0/10",
+			},
+			expected: "100.64.0.0/10",
+		},
+		{
+			desc:        "neither field set is an error",
+			spec:        kops.ClusterSpec{},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			tf := &TemplateFunctions{
+				cluster: &kops.Cluster{Spec: test.spec},
+			}
+			actual, err := tf.PodCIDR()
+			if test.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got %q", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("unexpected PodCIDR: %q instead of %q", actual, test.expected)
+			}
+		})
+	}
+}
+
+func Test_TemplateFunctions_SubnetsOfType(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Subnets: []kops.ClusterSubnetSpec{
+					{Name: "us-test-1b", Type: kops.SubnetTypePrivate},
+					{Name: "utility-us-test-1a", Type: kops.SubnetTypeUtility},
+					{Name: "us-test-1a", Type: kops.SubnetTypePrivate},
+				},
+			},
+		},
+	}
+
+	private, err := tf.SubnetsOfType("Private")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, s := range private {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"us-test-1a", "us-test-1b"}) {
+		t.Errorf("expected name-sorted private subnets, got %v", names)
+	}
+
+	utility, err := tf.SubnetsOfType("Utility")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(utility) != 1 || utility[0].Name != "utility-us-test-1a" {
+		t.Errorf("unexpected utility subnets: %v", utility)
+	}
+
+	if _, err := tf.SubnetsOfType("Pubic"); err == nil {
+		t.Errorf("expected error for misspelled subnet type")
+	}
+}
+
+func Test_TemplateFunctions_GetSubnet(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Subnets: []kops.ClusterSubnetSpec{
+					{Name: "us-test-1a", CIDR: "172.20.32.0/19", Zone: "us-test-1a"},
+					{Name: "us-test-1b", CIDR: "172.20.64.0/19", Zone: "us-test-1b"},
+				},
+			},
+		},
+	}
+
+	subnet, err := tf.GetSubnet("us-test-1b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if subnet.CIDR != "172.20.64.0/19" {
+		t.Errorf("unexpected subnet CIDR: %q", subnet.CIDR)
+	}
+
+	_, err = tf.GetSubnet("us-test-1c")
+	if err == nil {
+		t.Fatalf("expected error for missing subnet")
+	}
+	if !strings.Contains(err.Error(), "us-test-1a") || !strings.Contains(err.Error(), "us-test-1b") {
+		t.Errorf("expected available subnet names in error, got: %v", err)
+	}
+
+	tf.cluster.Spec.Subnets = append(tf.cluster.Spec.Subnets, kops.ClusterSubnetSpec{Name: "us-test-1a"})
+	if _, err := tf.GetSubnet("us-test-1a"); err == nil {
+		t.Errorf("expected error for duplicate subnet name")
+	}
+}
+
+func Test_TemplateFunctions_GetSubnetsForRole(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{
+			Spec: kops.ClusterSpec{
+				Subnets: []kops.ClusterSubnetSpec{
+					{Name: "us-test-1a", Type: kops.SubnetTypePrivate},
+					{Name: "utility-us-test-1a", Type: kops.SubnetTypeUtility},
+					{Name: "us-test-1b", Type: kops.SubnetTypePrivate},
+				},
+			},
+		},
+	}
+
+	private, err := tf.GetSubnetsForRole("Private")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, s := range private {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"us-test-1a", "us-test-1b"}) {
+		t.Errorf("unexpected private subnets: %v", names)
+	}
+
+	public, err := tf.GetSubnetsForRole("Public")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(public) != 0 {
+		t.Errorf("expected no public subnets, got %v", public)
+	}
+
+	if _, err := tf.GetSubnetsForRole("Internal"); err == nil {
+		t.Errorf("expected error for unknown subnet role")
+	}
+}
+
+func Test_TemplateFunctions_Base64Gzip(t *testing.T) {
+	tf := &TemplateFunctions{
+		cluster: &kops.Cluster{},
+	}
+
+	// A multi-kilobyte input, as rendered bootstrap files tend to be
+	input := strings.Repeat("#!/bin/bash\necho bootstrap\n", 500)
+
+	encoded, err := tf.Base64Gzip(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	again, err := tf.Base64Gzip(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if encoded != again {
+		t.Errorf("Base64Gzip output is not deterministic")
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("output is not valid base64: %v", err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("output is not valid gzip: %v", err)
+	}
+	decompressed, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("error decompressing: %v", err)
+	}
+	if string(decompressed) != input {
+		t.Errorf("round-trip differs: got %d bytes, expected %d", len(decompressed), len(input))
+	}
+}
+
 func Test_executeTemplate(t *testing.T) {
 	tests := []struct {
 		desc                 string